@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/n0madic/go-poe/client"
 	"github.com/n0madic/go-poe/models"
@@ -21,7 +25,29 @@ func (s *stringSlice) Set(val string) error {
 	return nil
 }
 
-// runCLI handles CLI mode subcommands (search, query).
+// optionalFloat implements flag.Value for float flags that are unset unless
+// given.
+type optionalFloat struct{ val *float64 }
+
+func (f *optionalFloat) String() string {
+	if f.val == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f.val, 'g', -1, 64)
+}
+func (f *optionalFloat) Set(val string) error {
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return err
+	}
+	f.val = &v
+	return nil
+}
+
+// stdin is where "-" arguments are read from; tests replace it.
+var stdin io.Reader = os.Stdin
+
+// runCLI handles CLI mode subcommands (search, model, query).
 func runCLI(args []string) error {
 	if len(args) == 0 {
 		printHelp()
@@ -35,6 +61,8 @@ func runCLI(args []string) error {
 		return nil
 	case "search":
 		return runSearch(args[1:])
+	case "model":
+		return runModel(args[1:])
 	case "query":
 		return runQuery(args[1:])
 	default:
@@ -51,6 +79,7 @@ func printHelp() {
 USAGE:
     poe-mcp              Start MCP server (stdio transport)
     poe-mcp search       Search and filter Poe model catalog
+    poe-mcp model        Show every catalog field of one model
     poe-mcp query        Query a Poe bot and stream response
 
 COMMANDS:
@@ -58,30 +87,87 @@ COMMANDS:
         Search the Poe model catalog (no API key required)
 
         Flags:
-          --owner string      Filter by owner/provider (e.g., OpenAI, Anthropic)
-          --modality string   Filter by modality (e.g., text, image)
+          --owner string                Filter by owner/provider (e.g., OpenAI, Anthropic)
+          --modality string             Filter by modality substring on either side (e.g., text, image)
+          --input-modality list         Only models accepting these inputs (comma-separated, repeatable)
+          --output-modality list        Only models producing these outputs (comma-separated, repeatable)
+          --modality-match mode         any or all of the listed modalities (default: any)
+          --sort field                  Sort by id, owner, context_length, prompt_price or completion_price
+          --order dir                   Sort direction: asc or desc (default: asc)
+          --limit n                     Show at most n models (default: all)
+          --offset n                    Skip the first n matches
+          --min-context n               Only models with a context window of at least n tokens
+          --min-max-output n            Only models that can output at least n tokens
+          --max-prompt-price usd        Maximum USD per million input tokens
+          --max-completion-price usd    Maximum USD per million output tokens
+          --max-request-price usd       Maximum USD per request
+          --has-pricing                 Only models that list pricing
 
         Examples:
           poe-mcp search "GPT-4o"
           poe-mcp search --owner OpenAI
           poe-mcp search --owner Google --modality text "pro"
+          poe-mcp search --sort context_length --order desc --limit 10
+          poe-mcp search --input-modality image --min-context 200000 --max-prompt-price 3
+          poe-mcp search --input-modality image,video --modality-match all
+
+    model <id>
+        Show every catalog field of one model, looked up by ID or display
+        name (case-insensitive; no API key required)
+
+        Examples:
+          poe-mcp model claude-sonnet-4.5
+          poe-mcp model "GPT-4o"
 
     query [flags] <bot> <message>
-        Query a Poe bot and stream the response (requires POE_API_KEY)
+        Query a Poe bot and stream the response (requires POE_API_KEY).
+        A message of "-" is read from stdin; -f - attaches stdin.
 
         Flags:
           -t, --temperature float   Sampling temperature 0.0-2.0 (default: 0.7)
-          -f, --file path/url       Attach a file: local path or URL (repeatable)
+          -f, --file path/url       Attach a file, directory, glob or URL (repeatable)
+          --thinking mode           Reasoning output: show, hide or dim (default: show)
+          --tool name               Offer a local tool to the bot: search_models, read_file (repeatable)
+          --max-tool-iterations n   Maximum tool call round trips (default: 5)
+          --usage                   Print token usage and estimated cost to stderr
+          --no-upload-cache         Re-upload files even if already uploaded recently
+          --allow-partial           Send the query even if some files fail to upload
+          --skip-modality-check     Do not check attachments against the bot's input modalities
+          --max-files n             Maximum files a directory or glob may expand to (default: 100)
+          --max-bytes n             Maximum bytes a directory or glob may expand to (default: 20 MB)
+          --attach-mode mode        Text files: upload, inline or auto; web fetches URLs as markdown (default: upload)
+          --inline-limit n          Largest text file inlined (default: 16 KB in auto, 1 MB in inline mode; max 1 MB)
+          --stdin-name name         File name for an attachment read from stdin with -f - (default: stdin.txt)
+          --raw                     Print only the final answer: no reasoning, no trailing newline
+          --max-image-dimension n   Downscale images whose longer side exceeds n pixels
+          --max-image-bytes n       Re-encode (and if needed downscale) images larger than n bytes
+          --keep-metadata           Upload images with EXIF/XMP/IPTC metadata (stripped by default)
+          --convert pattern         Convert matching .docx/.xlsx/.pptx/.ipynb files to text (repeatable; * for all)
+          --map-reduce              Answer over a single -f document larger than the context window, chunk by chunk
+          --chunk-tokens n          Chunk size for --map-reduce (default: from the bot's context length)
+          --overlap-tokens n        Tokens shared by neighbouring chunks (default: 5% of the chunk size)
 
         Examples:
           POE_API_KEY=<key> poe-mcp query GPT-4o "What is Go?"
           POE_API_KEY=<key> poe-mcp query -t 0.9 Claude-4.5-Sonnet "Explain monads"
           POE_API_KEY=<key> poe-mcp query --file photo.jpg GPT-4o "Describe this image"
           POE_API_KEY=<key> poe-mcp query -f https://example.com/doc.pdf GPT-4o "Summarize"
+          POE_API_KEY=<key> poe-mcp query -f 'src/**/*.go' GPT-4o "Review this package"
+          POE_API_KEY=<key> poe-mcp query --attach-mode auto -f main.go GPT-4o "Find the bug"
+          POE_API_KEY=<key> poe-mcp query --attach-mode web -f https://go.dev/blog/go1.23 GPT-4o "Summarize"
+          POE_API_KEY=<key> poe-mcp query --convert '*' -f report.docx -f data.xlsx GPT-4o "Summarize"
+          git diff | POE_API_KEY=<key> poe-mcp query -f - --stdin-name diff.patch GPT-4o "Review this diff"
+          echo "What is Go?" | POE_API_KEY=<key> poe-mcp query --raw GPT-4o -
+          POE_API_KEY=<key> poe-mcp query --map-reduce -f book.txt GPT-4o "List every character"
+          POE_API_KEY=<key> poe-mcp query --thinking=hide DeepSeek-R1 "Prove it"
+          POE_API_KEY=<key> poe-mcp query --tool search_models GPT-4o "Which Google models take video?"
 
 ENVIRONMENT VARIABLES:
     POE_API_KEY    Required for MCP server mode and 'query' command
-                   Not required for 'search' command`)
+                   Not required for 'search' and 'model' commands
+    POE_MCP_UPLOAD_CACHE_DIR
+                   Directory for persisting the upload cache across runs
+    POE_MCP_CONFIG Path to a JSON config file (URL policy, allowed roots)`)
 }
 
 // runSearch handles the 'search' subcommand.
@@ -93,16 +179,47 @@ func runSearch(args []string) error {
 Search and filter the Poe model catalog (no API key required).
 
 FLAGS:
-  --owner string      Filter by owner/provider (e.g., OpenAI, Anthropic)
-  --modality string   Filter by modality (e.g., text, image)
+  --owner string                Filter by owner/provider (e.g., OpenAI, Anthropic)
+  --modality string             Filter by modality substring on either side (e.g., text, image)
+  --input-modality list         Only models accepting these inputs (comma-separated, repeatable)
+  --output-modality list        Only models producing these outputs (comma-separated, repeatable)
+  --modality-match mode         any or all of the listed modalities (default: any)
+  --sort field                  Sort by id, owner, context_length, prompt_price or completion_price
+  --order dir                   Sort direction: asc or desc (default: asc)
+  --limit n                     Show at most n models (default: all)
+  --offset n                    Skip the first n matches
+  --min-context n               Only models with a context window of at least n tokens
+  --min-max-output n            Only models that can output at least n tokens
+  --max-prompt-price usd        Maximum USD per million input tokens
+  --max-completion-price usd    Maximum USD per million output tokens
+  --max-request-price usd       Maximum USD per request
+  --has-pricing                 Only models that list pricing
 
 EXAMPLES:
   poe-mcp search "GPT-4o"
   poe-mcp search --owner OpenAI
-  poe-mcp search --owner Google --modality text "pro"`)
+  poe-mcp search --owner Google --modality text "pro"
+  poe-mcp search --sort context_length --order desc --limit 10
+  poe-mcp search --input-modality image --min-context 200000 --max-prompt-price 3
+  poe-mcp search --input-modality image,video --modality-match all`)
 	}
 	owner := fs.String("owner", "", "Filter by owner/provider (e.g., OpenAI, Anthropic)")
-	modality := fs.String("modality", "", "Filter by modality (e.g., text, image)")
+	modality := fs.String("modality", "", "Filter by modality substring on either side (e.g., text, image)")
+	var inputModality, outputModality stringSlice
+	fs.Var(&inputModality, "input-modality", "Only models accepting these inputs (comma-separated, repeatable)")
+	fs.Var(&outputModality, "output-modality", "Only models producing these outputs (comma-separated, repeatable)")
+	modalityMatch := fs.String("modality-match", "any", "any or all of the listed modalities")
+	sortBy := fs.String("sort", "", "Sort by id, owner, context_length, prompt_price or completion_price")
+	order := fs.String("order", "asc", "Sort direction: asc or desc")
+	limit := fs.Int("limit", 0, "Show at most this many models (0 for all)")
+	offset := fs.Int("offset", 0, "Skip this many matches")
+	minContext := fs.Int("min-context", 0, "Only models with a context window of at least this many tokens")
+	minMaxOutput := fs.Int("min-max-output", 0, "Only models that can output at least this many tokens")
+	var maxPrompt, maxCompletion, maxRequest optionalFloat
+	fs.Var(&maxPrompt, "max-prompt-price", "Maximum USD per million input tokens")
+	fs.Var(&maxCompletion, "max-completion-price", "Maximum USD per million output tokens")
+	fs.Var(&maxRequest, "max-request-price", "Maximum USD per request")
+	hasPricing := fs.Bool("has-pricing", false, "Only models that list pricing")
 
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
@@ -126,16 +243,70 @@ EXAMPLES:
 		Query:    query,
 		OwnedBy:  *owner,
 		Modality: *modality,
+		SortBy:   *sortBy,
+		Order:    *order,
+		Limit:    *limit,
+		Offset:   *offset,
+
+		InputModality:  inputModality,
+		OutputModality: outputModality,
+		ModalityMatch:  *modalityMatch,
+
+		MinContext:         *minContext,
+		MinMaxOutput:       *minMaxOutput,
+		MaxPromptPrice:     maxPrompt.val,
+		MaxCompletionPrice: maxCompletion.val,
+		MaxRequestPrice:    maxRequest.val,
+		HasPricing:         *hasPricing,
+	}
+	page, err := searchModels(all, searchArgs)
+	if err != nil {
+		return err
 	}
-	matched := filterModels(all, searchArgs)
 
-	if len(matched) == 0 {
+	if page.Total == 0 {
 		fmt.Println("No models found matching the given criteria.")
 		return nil
 	}
 
 	// Print formatted results
-	fmt.Print(formatModels(matched))
+	fmt.Print(formatModelPage(page))
+	return nil
+}
+
+// runModel handles the 'model' subcommand.
+func runModel(args []string) error {
+	fs := flag.NewFlagSet("model", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Println(`Usage: poe-mcp model <id>
+
+Show every catalog field of one model (no API key required). The model is
+looked up by ID or display name, case-insensitively; when nothing matches,
+the closest IDs are suggested.
+
+EXAMPLES:
+  poe-mcp model claude-sonnet-4.5
+  poe-mcp model "GPT-4o"`)
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: model <id>")
+	}
+
+	all, err := models.Fetch(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("error fetching models: %w", err)
+	}
+	m, err := resolveModel(all, strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+	fmt.Print(formatModelDetail(*m))
 	return nil
 }
 
@@ -146,23 +317,76 @@ func runQuery(args []string) error {
 		fmt.Println(`Usage: poe-mcp query [flags] <bot> <message>
 
 Query a Poe bot and stream the response (requires POE_API_KEY).
+A message of "-" is read from stdin; -f - attaches stdin.
 
 FLAGS:
   -t, --temperature float   Sampling temperature 0.0-2.0 (default: 0.7)
-  -f, --file path/url       Attach a file: local path or URL (repeatable)
+  -f, --file path/url       Attach a file, directory, glob or URL (repeatable)
+  --thinking mode           Reasoning output: show, hide or dim (default: show)
+  --tool name               Offer a local tool to the bot: search_models, read_file (repeatable)
+  --max-tool-iterations n   Maximum tool call round trips (default: 5)
+  --usage                   Print token usage and estimated cost to stderr
+  --no-upload-cache         Re-upload files even if already uploaded recently
+  --allow-partial           Send the query even if some files fail to upload
+  --skip-modality-check     Do not check attachments against the bot's input modalities
+  --max-files n             Maximum files a directory or glob may expand to (default: 100)
+  --max-bytes n             Maximum bytes a directory or glob may expand to (default: 20 MB)
+  --attach-mode mode        Text files: upload, inline or auto; web fetches URLs as markdown (default: upload)
+  --inline-limit n          Largest text file inlined (default: 16 KB in auto, 1 MB in inline mode; max 1 MB)
+  --stdin-name name         File name for an attachment read from stdin with -f - (default: stdin.txt)
+  --raw                     Print only the final answer: no reasoning, no trailing newline
+  --max-image-dimension n   Downscale images whose longer side exceeds n pixels
+  --max-image-bytes n       Re-encode (and if needed downscale) images larger than n bytes
+  --keep-metadata           Upload images with EXIF/XMP/IPTC metadata (stripped by default)
+  --convert pattern         Convert matching .docx/.xlsx/.pptx/.ipynb files to text (repeatable; * for all)
+  --map-reduce              Answer over a single -f document larger than the context window, chunk by chunk
+  --chunk-tokens n          Chunk size for --map-reduce (default: from the bot's context length)
+  --overlap-tokens n        Tokens shared by neighbouring chunks (default: 5% of the chunk size)
 
 EXAMPLES:
   POE_API_KEY=<key> poe-mcp query GPT-4o "What is Go?"
   POE_API_KEY=<key> poe-mcp query -t 0.9 Claude-4.5-Sonnet "Explain monads"
   POE_API_KEY=<key> poe-mcp query -f photo.jpg GPT-4o "Describe this image"
-  POE_API_KEY=<key> poe-mcp query -f https://example.com/doc.pdf GPT-4o "Summarize"`)
+  POE_API_KEY=<key> poe-mcp query -f https://example.com/doc.pdf GPT-4o "Summarize"
+  POE_API_KEY=<key> poe-mcp query -f 'src/**/*.go' GPT-4o "Review this package"
+  POE_API_KEY=<key> poe-mcp query --attach-mode auto -f main.go GPT-4o "Find the bug"
+  POE_API_KEY=<key> poe-mcp query --attach-mode web -f https://go.dev/blog/go1.23 GPT-4o "Summarize"
+  POE_API_KEY=<key> poe-mcp query --convert '*' -f report.docx -f data.xlsx GPT-4o "Summarize"
+  git diff | POE_API_KEY=<key> poe-mcp query -f - --stdin-name diff.patch GPT-4o "Review this diff"
+  echo "What is Go?" | POE_API_KEY=<key> poe-mcp query --raw GPT-4o -
+  POE_API_KEY=<key> poe-mcp query --map-reduce -f book.txt GPT-4o "List every character"
+  POE_API_KEY=<key> poe-mcp query --thinking=hide DeepSeek-R1 "Prove it"
+  POE_API_KEY=<key> poe-mcp query --tool search_models GPT-4o "Which Google models take video?"`)
 	}
 	temperature := fs.Float64("t", 0.7, "Sampling temperature (0.0-2.0)")
 	fs.Float64("temperature", 0.7, "Sampling temperature (0.0-2.0)") // Alias
 
 	var files stringSlice
-	fs.Var(&files, "f", "Attach a file: local path or URL (repeatable)")
-	fs.Var(&files, "file", "Attach a file: local path or URL (repeatable)")
+	fs.Var(&files, "f", "Attach a file, directory, glob or URL (repeatable)")
+	fs.Var(&files, "file", "Attach a file, directory, glob or URL (repeatable)")
+	thinking := fs.String("thinking", string(thinkingShow), "Reasoning output: show, hide or dim")
+
+	var tools stringSlice
+	fs.Var(&tools, "tool", "Offer a local tool to the bot (repeatable)")
+	maxToolIter := fs.Int("max-tool-iterations", defaultMaxToolIterations, "Maximum tool call round trips")
+	showUsage := fs.Bool("usage", false, "Print token usage and estimated cost to stderr")
+	noUploadCache := fs.Bool("no-upload-cache", false, "Re-upload files even if already uploaded recently")
+	allowPartial := fs.Bool("allow-partial", false, "Send the query even if some files fail to upload")
+	skipModalityCheck := fs.Bool("skip-modality-check", false, "Do not check attachments against the bot's input modalities")
+	maxFiles := fs.Int("max-files", defaultMaxFiles, "Maximum files a directory or glob may expand to")
+	maxBytes := fs.Int("max-bytes", defaultMaxBytes, "Maximum total bytes a directory or glob may expand to")
+	attachModeFlag := fs.String("attach-mode", string(attachUpload), "Text files: upload, inline or auto; web fetches URLs as markdown")
+	inlineLimit := fs.Int("inline-limit", 0, "Largest text file inlined (default: 16 KB in auto, 1 MB in inline mode; max 1 MB)")
+	stdinName := fs.String("stdin-name", defaultStdinName, "File name for an attachment read from stdin with -f -")
+	raw := fs.Bool("raw", false, "Print only the final answer: no reasoning, no trailing newline")
+	maxImageDim := fs.Int("max-image-dimension", 0, "Downscale images whose longer side exceeds this many pixels")
+	maxImageBytes := fs.Int("max-image-bytes", 0, "Re-encode images larger than this many bytes")
+	keepMetadata := fs.Bool("keep-metadata", false, "Upload images with their EXIF, XMP and IPTC metadata")
+	var convert stringSlice
+	fs.Var(&convert, "convert", "Convert matching .docx, .xlsx, .pptx and .ipynb files to text (repeatable; * for all)")
+	mapReduceMode := fs.Bool("map-reduce", false, "Answer over a single -f document chunk by chunk")
+	chunkTokens := fs.Int("chunk-tokens", 0, "Chunk size in tokens for --map-reduce")
+	overlapTokens := fs.Int("overlap-tokens", 0, "Tokens shared by neighbouring chunks for --map-reduce")
 
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
@@ -171,6 +395,15 @@ EXAMPLES:
 		return err
 	}
 
+	mode, err := parseThinkingMode(*thinking)
+	if err != nil {
+		return err
+	}
+	attach, err := parseAttachMode(*attachModeFlag)
+	if err != nil {
+		return err
+	}
+
 	// Two positional args required: <bot> <message>
 	positional := fs.Args()
 	if len(positional) < 2 {
@@ -179,6 +412,9 @@ EXAMPLES:
 
 	bot := positional[0]
 	message := strings.Join(positional[1:], " ")
+	if *raw {
+		mode = thinkingHide
+	}
 
 	// POE_API_KEY is required for querying
 	apiKey := os.Getenv("POE_API_KEY")
@@ -186,16 +422,67 @@ EXAMPLES:
 		return fmt.Errorf("POE_API_KEY environment variable is required for query command")
 	}
 
+	// "-" reads the message or a single attachment from stdin
+	var stdinData []byte
+	if message == "-" || containsString(files, "-") {
+		if message == "-" && containsString(files, "-") {
+			return fmt.Errorf("stdin can be used for the message or for an attachment, not both")
+		}
+		stdinData, err = io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("reading stdin: %w", err)
+		}
+		if len(stdinData) == 0 {
+			return fmt.Errorf("stdin is empty")
+		}
+		if message == "-" {
+			message, stdinData = string(stdinData), nil
+		}
+	}
+
 	ctx := context.Background()
 
-	// Upload attached files
+	if *mapReduceMode {
+		if len(files) != 1 {
+			return fmt.Errorf("--map-reduce needs exactly one -f document")
+		}
+		return runMapReduce(ctx, bot, message, apiKey, files[0], stdinData, temperature,
+			mapReduceOptions{ChunkTokens: *chunkTokens, OverlapTokens: *overlapTokens}, *raw, *showUsage)
+	}
+
+	// Upload attached files. Text files are parsed into the prompt by Poe, so
+	// the meter counts them.
 	var attachments []types.Attachment
+	meter := &usageMeter{}
 	if len(files) > 0 {
-		uploaded, err := uploadFiles(ctx, files, apiKey)
+		uploaded, err := uploadFiles(ctx, files, apiKey, uploadOptions{
+			NoCache:           *noUploadCache,
+			Bot:               bot,
+			SkipModalityCheck: *skipModalityCheck,
+			MaxFiles:          *maxFiles,
+			MaxBytes:          *maxBytes,
+			Mode:              attach,
+			InlineLimit:       *inlineLimit,
+			Stdin:             stdinData,
+			StdinName:         *stdinName,
+			MaxImageDimension: *maxImageDim,
+			MaxImageBytes:     *maxImageBytes,
+			KeepMetadata:      *keepMetadata,
+			Convert:           convert,
+		})
 		if err != nil {
-			return fmt.Errorf("file upload: %w", err)
+			var upErr *uploadError
+			if !*allowPartial || !errors.As(err, &upErr) || (len(uploaded.Attachments) == 0 && uploaded.Inline == "") {
+				return fmt.Errorf("file upload: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: continuing without failed files: %v\n", err)
+		}
+		for _, r := range uploaded.Resized {
+			fmt.Fprintf(os.Stderr, "Resized image %s\n", r)
 		}
-		attachments = uploaded
+		attachments = uploaded.Attachments
+		message += uploaded.Inline
+		meter.attachedText = uploaded.TextBytes
 	}
 
 	// Construct the message
@@ -218,7 +505,35 @@ EXAMPLES:
 		Temperature: temperature,
 	}
 
+	out := newThinkingPrinter(os.Stdout, mode)
+
+	// With tools enabled the bot's turns are collected rather than streamed,
+	// since tool calls must be executed between them.
+	if len(tools) > 0 {
+		resp, steps, err := runToolLoop(ctx, req, bot, apiKey, tools, *maxToolIter, sandboxForRequest(ctx, nil), meter)
+		if len(steps) > 0 {
+			fmt.Fprint(os.Stderr, formatTranscript(steps))
+		}
+		if *showUsage {
+			defer func() {
+				if u := meter.usage(ctx, bot); u != nil {
+					fmt.Fprintln(os.Stderr, "Usage: "+u.String())
+				}
+			}()
+		}
+		if err != nil {
+			return err
+		}
+		out.Write(resp.Text)
+		out.Flush()
+		if !*raw {
+			fmt.Println()
+		}
+		return nil
+	}
+
 	ch := client.StreamRequest(ctx, req, bot, opts)
+	full := &client.BotResponse{}
 
 	// Print each chunk as it arrives
 	for chunk := range ch {
@@ -232,12 +547,152 @@ EXAMPLES:
 			continue
 		}
 
+		// Keep the full response for usage reporting
+		if chunk.IsReplaceResponse {
+			full.Text = ""
+		}
+		full.Text += chunk.Text
+		if chunk.Attachment != nil {
+			full.Attachments = append(full.Attachments, *chunk.Attachment)
+		}
+		if chunk.Data != nil {
+			full.Data = append(full.Data, chunk.Data)
+		}
+
 		// Print the text chunk
-		if chunk.Text != "" {
-			fmt.Print(chunk.Text)
+		if chunk.IsReplaceResponse {
+			out.Replace(chunk.Text)
+		} else if chunk.Text != "" {
+			out.Write(chunk.Text)
 		}
 	}
+	out.Flush()
 
-	fmt.Println() // Newline at the end
+	if !*raw {
+		fmt.Println() // Newline at the end
+	}
+	if *showUsage {
+		meter.observe(req, full)
+		fmt.Fprintln(os.Stderr, "Usage: "+meter.usage(ctx, bot).String())
+	}
+	return nil
+}
+
+// runMapReduce answers question over a document too large for one query,
+// reporting progress on stderr, and with showUsage the usage of all map and
+// reduce queries.
+func runMapReduce(ctx context.Context, bot, question, key, file string, stdinData []byte, temperature *float64, opts mapReduceOptions, raw, showUsage bool) error {
+	var doc string
+	if file == "-" {
+		if !utf8.Valid(stdinData) {
+			return fmt.Errorf("stdin is not UTF-8 text")
+		}
+		doc = string(stdinData)
+	} else {
+		var err error
+		if doc, err = loadDocument(ctx, FileInput{Path: file}, nil); err != nil {
+			return err
+		}
+	}
+	opts = opts.withDefaults(ctx, bot, question)
+	meter := &usageMeter{}
+	if showUsage {
+		defer func() {
+			if u := meter.usage(ctx, bot); u != nil {
+				fmt.Fprintln(os.Stderr, "Usage: "+u.String())
+			}
+		}()
+	}
+	res, err := mapReduce(ctx, doc, question, botAsker(bot, key, temperature, meter), opts, func(done, total int, msg string) {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", done, total, msg)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print(res.Answer)
+	if !raw {
+		fmt.Println()
+	}
 	return nil
 }
+
+// thinkingPrinter renders streamed bot output according to a thinkingMode.
+type thinkingPrinter struct {
+	w         io.Writer
+	mode      thinkingMode
+	parser    thinkingParser
+	reasoning bool // dim style is currently active
+	printed   bool // some output has been written
+}
+
+func newThinkingPrinter(w io.Writer, mode thinkingMode) *thinkingPrinter {
+	return &thinkingPrinter{w: w, mode: mode}
+}
+
+// Write prints a streamed chunk, classifying it unless the mode is "show".
+func (p *thinkingPrinter) Write(chunk string) {
+	if p.mode == thinkingShow {
+		p.write(chunk)
+		return
+	}
+	p.print(p.parser.Write(chunk))
+}
+
+// Replace starts over with text, which replaces everything the bot has sent
+// so far. Output already on the terminal cannot be taken back, so the
+// replacement starts on a new line and is classified afresh.
+func (p *thinkingPrinter) Replace(text string) {
+	if p.reasoning {
+		fmt.Fprint(p.w, "\x1b[0m")
+		p.reasoning = false
+	}
+	if p.printed {
+		fmt.Fprintln(p.w)
+		p.printed = false
+	}
+	p.parser = thinkingParser{}
+	p.Write(text)
+}
+
+func (p *thinkingPrinter) write(text string) {
+	if text != "" {
+		fmt.Fprint(p.w, text)
+		p.printed = true
+	}
+}
+
+// Flush prints any text still buffered by the parser.
+func (p *thinkingPrinter) Flush() {
+	if p.mode == thinkingShow {
+		return
+	}
+	p.print(p.parser.Flush())
+	if p.reasoning {
+		fmt.Fprint(p.w, "\x1b[0m")
+		p.reasoning = false
+	}
+}
+
+func (p *thinkingPrinter) print(segs []segment) {
+	for _, seg := range segs {
+		switch {
+		case seg.Reasoning && p.mode == thinkingHide:
+			continue
+		case seg.Reasoning:
+			if !p.reasoning {
+				fmt.Fprint(p.w, "\x1b[2m")
+				p.reasoning = true
+			}
+			p.write(seg.Text)
+		default:
+			text := seg.Text
+			if p.reasoning {
+				// Reset the dim style and separate reasoning from the answer.
+				fmt.Fprint(p.w, "\x1b[0m")
+				text = "\n" + strings.TrimLeft(text, "\n")
+				p.reasoning = false
+			}
+			p.write(text)
+		}
+	}
+}
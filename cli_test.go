@@ -69,3 +69,32 @@ func TestRunQuery_MissingAPIKey(t *testing.T) {
 		t.Errorf("Expected POE_API_KEY error, got: %v", err)
 	}
 }
+
+func TestRunQuery_Stdin(t *testing.T) {
+	origKey := os.Getenv("POE_API_KEY")
+	defer os.Setenv("POE_API_KEY", origKey)
+	os.Setenv("POE_API_KEY", "test-key")
+
+	origStdin := stdin
+	defer func() { stdin = origStdin }()
+
+	tests := []struct {
+		name    string
+		args    []string
+		input   string
+		wantErr string
+	}{
+		{"empty stdin message", []string{"GPT-4o", "-"}, "", "stdin is empty"},
+		{"empty stdin attachment", []string{"-f", "-", "GPT-4o", "review"}, "", "stdin is empty"},
+		{"stdin used twice", []string{"-f", "-", "GPT-4o", "-"}, "data", "not both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin = strings.NewReader(tt.input)
+			err := runQuery(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("runQuery(%v) error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
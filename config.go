@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// config holds settings read from the JSON file named by the POE_MCP_CONFIG
+// environment variable. Every field is optional.
+type config struct {
+	// AllowedRoots lists the directories query_bot may read local files from
+	// when the MCP client does not provide roots of its own.
+	AllowedRoots []string `json:"allowed_roots,omitempty"`
+	// DenyPatterns are added to defaultDenyPatterns.
+	DenyPatterns []string `json:"deny_patterns,omitempty"`
+	// URLPolicy restricts URL attachments and local downloads.
+	URLPolicy urlPolicy `json:"url_policy,omitempty"`
+	// URLHeaders adds credentials to downloads from matching hosts.
+	URLHeaders []headerRule `json:"url_headers,omitempty"`
+}
+
+// cfg is the loaded server configuration; the zero value applies defaults.
+var cfg config
+
+// loadConfig reads the config file at path, if any, and appends the roots
+// listed in POE_MCP_ALLOWED_ROOTS (separated like PATH).
+func loadConfig(path string) (config, error) {
+	var c config
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("reading config: %w", err)
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+		for i, r := range c.URLHeaders {
+			if err := r.validate(i); err != nil {
+				return c, fmt.Errorf("config %s: %w", path, err)
+			}
+		}
+	}
+	if roots := os.Getenv("POE_MCP_ALLOWED_ROOTS"); roots != "" {
+		for _, r := range filepath.SplitList(roots) {
+			if r != "" {
+				c.AllowedRoots = append(c.AllowedRoots, r)
+			}
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("POE_MCP_ALLOWED_ROOTS", "/srv/a"+string(os.PathListSeparator)+"/srv/b")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"allowed_roots": ["/home/u/project"], "deny_patterns": ["*.sqlite"]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"/home/u/project", "/srv/a", "/srv/b"}
+	if len(c.AllowedRoots) != len(want) {
+		t.Fatalf("AllowedRoots = %v, want %v", c.AllowedRoots, want)
+	}
+	for i := range want {
+		if c.AllowedRoots[i] != want[i] {
+			t.Errorf("AllowedRoots[%d] = %q, want %q", i, c.AllowedRoots[i], want[i])
+		}
+	}
+	if len(c.DenyPatterns) != 1 || c.DenyPatterns[0] != "*.sqlite" {
+		t.Errorf("DenyPatterns = %v", c.DenyPatterns)
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for missing config file")
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(path); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
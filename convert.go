@@ -0,0 +1,770 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxConvertFileBytes caps the files read for conversion.
+	maxConvertFileBytes = 50 * 1024 * 1024
+	// maxConvertPartBytes caps each decompressed part read from an Office
+	// file, guarding against zip bombs.
+	maxConvertPartBytes = 50 * 1024 * 1024
+	// maxSheetRows and maxSheetColumns are Excel's worksheet limits; cell
+	// references beyond them are rejected.
+	maxSheetRows    = 1 << 20
+	maxSheetColumns = 1 << 14
+	// maxSheetCells caps the cells of a worksheet once empty rows and
+	// columns are padded, so a few far-apart cells cannot blow up the CSV.
+	maxSheetCells = 4 << 20
+)
+
+// converters turn Office documents and notebooks into text, keyed by file
+// extension.
+var converters = map[string]func(name string, data []byte) ([]attachment, error){
+	".docx":  convertDOCX,
+	".xlsx":  convertXLSX,
+	".pptx":  convertPPTX,
+	".ipynb": convertIPYNB,
+}
+
+// convertDocuments replaces the .docx, .xlsx, .pptx and .ipynb files selected
+// by patterns with text converted locally: markdown for documents, slides and
+// notebooks, and one CSV file per worksheet. A pattern selects a file when it
+// matches its name or the path it was given as; "*" selects every convertible
+// file. URLs are never converted.
+func convertDocuments(atts []attachment, patterns []string) ([]attachment, error) {
+	if len(patterns) == 0 {
+		return atts, nil
+	}
+	var out []attachment
+	for _, a := range atts {
+		convert := converters[strings.ToLower(filepath.Ext(a.Name))]
+		if convert == nil || (a.Data == nil && (a.Path == "" || isURL(a.Path))) || !selectedForConversion(a, patterns) {
+			out = append(out, a)
+			continue
+		}
+		data := a.Data
+		if data == nil {
+			info, err := os.Stat(a.Path)
+			if err != nil {
+				return nil, fmt.Errorf("converting %s: %w", a.Source, err)
+			}
+			if info.Size() > maxConvertFileBytes {
+				return nil, fmt.Errorf("converting %s: file exceeds %d bytes", a.Source, maxConvertFileBytes)
+			}
+			if data, err = os.ReadFile(a.Path); err != nil {
+				return nil, fmt.Errorf("converting %s: %w", a.Source, err)
+			}
+		} else if len(data) > maxConvertFileBytes {
+			return nil, fmt.Errorf("converting %s: file exceeds %d bytes", a.Source, maxConvertFileBytes)
+		}
+		converted, err := convert(a.Name, data)
+		if err != nil {
+			return nil, fmt.Errorf("converting %s: %w", a.Source, err)
+		}
+		for i := range converted {
+			converted[i].Source = a.Source
+		}
+		out = append(out, converted...)
+	}
+	return out, nil
+}
+
+func selectedForConversion(a attachment, patterns []string) bool {
+	source := filepath.ToSlash(a.Source)
+	for _, p := range patterns {
+		p = filepath.ToSlash(strings.TrimSpace(p))
+		if p == "*" || p == source {
+			return true
+		}
+		if ok, _ := path.Match(p, a.Name); ok {
+			return true
+		}
+		if matchGlob(p, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// textAttachment builds the converted attachment for name, keeping the
+// original name so the bot can tell where the text came from.
+func textAttachment(name, ext, text string) attachment {
+	return attachment{Name: name + ext, Data: []byte(text)}
+}
+
+// zipParts gives access to the parts of an Office Open XML package.
+type zipParts struct {
+	files map[string]*zip.File
+}
+
+func openZipParts(data []byte) (*zipParts, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not an Office document: %w", err)
+	}
+	p := &zipParts{files: map[string]*zip.File{}}
+	for _, f := range zr.File {
+		p.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	return p, nil
+}
+
+// read returns a part's content; missing parts are reported as os.ErrNotExist.
+func (p *zipParts) read(name string) ([]byte, error) {
+	f, ok := p.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxConvertPartBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(data) > maxConvertPartBytes {
+		return nil, fmt.Errorf("%s exceeds %d bytes", name, maxConvertPartBytes)
+	}
+	return data, nil
+}
+
+// relationships maps the relationship IDs of a part to the part names they
+// point to, as listed in <dir>/_rels/<file>.rels.
+func (p *zipParts) relationships(part string) (map[string]string, error) {
+	dir, file := path.Split(part)
+	data, err := p.read(dir + "_rels/" + file + ".rels")
+	if err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for _, r := range rels.Items {
+		if strings.HasPrefix(r.Target, "/") {
+			out[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			out[r.ID] = path.Join(dir, r.Target)
+		}
+	}
+	return out, nil
+}
+
+// relID returns the namespaced r:id attribute, which references a
+// relationship.
+func relID(attrs []xml.Attr) string {
+	for _, a := range attrs {
+		if a.Name.Local == "id" && a.Name.Space != "" {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func attrValue(attrs []xml.Attr, local string) string {
+	for _, a := range attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// convertDOCX renders a Word document as markdown: headings, list items,
+// paragraphs and tables.
+func convertDOCX(name string, data []byte) ([]attachment, error) {
+	parts, err := openZipParts(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parts.read("word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	type table struct {
+		rows [][]string
+		row  []string
+		cell []string
+	}
+	var (
+		out     strings.Builder
+		tables  []*table
+		para    strings.Builder
+		heading int
+		list    bool
+		inText  bool
+		runs    int // open w:r elements; w:tab outside them is a tab stop
+	)
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("word/document.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tbl":
+				tables = append(tables, &table{})
+			case "tr":
+				if len(tables) > 0 {
+					tables[len(tables)-1].row = nil
+				}
+			case "tc":
+				if len(tables) > 0 {
+					tables[len(tables)-1].cell = nil
+				}
+			case "p":
+				para.Reset()
+				heading, list = 0, false
+			case "pStyle":
+				heading = headingLevel(attrValue(t.Attr, "val"))
+			case "numPr":
+				list = true
+			case "r":
+				runs++
+			case "t":
+				inText = true
+			case "tab":
+				if runs > 0 {
+					para.WriteString("\t")
+				}
+			case "br", "cr":
+				para.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "r":
+				runs--
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(para.String())
+				if len(tables) > 0 {
+					if text != "" {
+						tbl := tables[len(tables)-1]
+						tbl.cell = append(tbl.cell, text)
+					}
+					continue
+				}
+				if text == "" {
+					continue
+				}
+				switch {
+				case heading > 0:
+					out.WriteString(strings.Repeat("#", heading) + " ")
+				case list:
+					out.WriteString("- ")
+				}
+				out.WriteString(text)
+				out.WriteString("\n\n")
+			case "tc":
+				if len(tables) > 0 {
+					tbl := tables[len(tables)-1]
+					tbl.row = append(tbl.row, strings.Join(tbl.cell, " "))
+				}
+			case "tr":
+				if len(tables) > 0 {
+					tbl := tables[len(tables)-1]
+					tbl.rows = append(tbl.rows, tbl.row)
+				}
+			case "tbl":
+				tbl := tables[len(tables)-1]
+				tables = tables[:len(tables)-1]
+				md := markdownTable(tbl.rows)
+				if len(tables) > 0 {
+					// Nested tables are flattened into the outer cell.
+					outer := tables[len(tables)-1]
+					outer.cell = append(outer.cell, strings.Join(flatten(tbl.rows), " "))
+					continue
+				}
+				if md != "" {
+					out.WriteString(md)
+					out.WriteString("\n")
+				}
+			}
+		}
+	}
+	return []attachment{textAttachment(name, ".md", strings.TrimSpace(out.String())+"\n")}, nil
+}
+
+// headingLevel maps Word paragraph styles such as Heading2 or Title to a
+// markdown heading level, or 0 for other styles.
+func headingLevel(style string) int {
+	s := strings.ToLower(style)
+	if s == "title" {
+		return 1
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(s, "heading")); err == nil && strings.HasPrefix(s, "heading") && n >= 1 {
+		return min(n, 6)
+	}
+	return 0
+}
+
+// markdownTable renders rows as a markdown table with the first row as its
+// header.
+func markdownTable(rows [][]string) string {
+	cols := 0
+	for _, r := range rows {
+		cols = max(cols, len(r))
+	}
+	if cols == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	writeRow := func(r []string) {
+		sb.WriteString("|")
+		for i := range cols {
+			cell := ""
+			if i < len(r) {
+				cell = strings.ReplaceAll(r[i], "|", `\|`)
+				cell = strings.ReplaceAll(cell, "\n", " ")
+			}
+			sb.WriteString(" " + cell + " |")
+		}
+		sb.WriteString("\n")
+	}
+	writeRow(rows[0])
+	sb.WriteString("|" + strings.Repeat(" --- |", cols) + "\n")
+	for _, r := range rows[1:] {
+		writeRow(r)
+	}
+	return sb.String()
+}
+
+func flatten(rows [][]string) []string {
+	var out []string
+	for _, r := range rows {
+		for _, c := range r {
+			if c != "" {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// xlsxText is a shared or inline string, made of plain text or rich text runs.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (s xlsxText) String() string {
+	if len(s.Runs) == 0 {
+		return s.T
+	}
+	var sb strings.Builder
+	for _, r := range s.Runs {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+// convertXLSX converts each non-empty worksheet of a workbook to a CSV file
+// named after the workbook and the sheet. Cells hold their stored values;
+// formulas are not evaluated and dates appear as serial numbers.
+func convertXLSX(name string, data []byte) ([]attachment, error) {
+	parts, err := openZipParts(data)
+	if err != nil {
+		return nil, err
+	}
+	wb, err := parts.read("xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	var workbook struct {
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(wb, &workbook); err != nil {
+		return nil, fmt.Errorf("xl/workbook.xml: %w", err)
+	}
+	rels, err := parts.relationships("xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	var shared []string
+	if ss, err := parts.read("xl/sharedStrings.xml"); err == nil {
+		var sst struct {
+			Items []xlsxText `xml:"si"`
+		}
+		if err := xml.Unmarshal(ss, &sst); err != nil {
+			return nil, fmt.Errorf("xl/sharedStrings.xml: %w", err)
+		}
+		for _, si := range sst.Items {
+			shared = append(shared, si.String())
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var out []attachment
+	for _, sheet := range workbook.Sheets {
+		target, ok := rels[relID(sheet.Attrs)]
+		if !ok {
+			continue // chart sheets and dangling references
+		}
+		raw, err := parts.read(target)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := sheetRows(raw, shared)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", sheet.Name, err)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.WriteAll(rows); err != nil {
+			return nil, err
+		}
+		out = append(out, textAttachment(name, "."+safeFileName(sheet.Name)+".csv", buf.String()))
+	}
+	if len(out) == 0 {
+		return nil, errors.New("workbook has no data")
+	}
+	return out, nil
+}
+
+// sheetRows reads a worksheet into rows of cell values, keeping empty rows
+// and columns between filled cells so positions are preserved. References
+// outside Excel's limits and sheets that would pad out to more than
+// maxSheetCells cells are errors.
+func sheetRows(data []byte, shared []string) ([][]string, error) {
+	var ws struct {
+		Rows []struct {
+			R     int `xml:"r,attr"`
+			Cells []struct {
+				R      string   `xml:"r,attr"`
+				T      string   `xml:"t,attr"`
+				V      string   `xml:"v"`
+				Inline xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(data, &ws); err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	width := 0
+	for _, row := range ws.Rows {
+		if row.R > maxSheetRows {
+			return nil, fmt.Errorf("row %d exceeds the %d-row limit", row.R, maxSheetRows)
+		}
+		idx := row.R - 1
+		if idx < len(rows) {
+			idx = len(rows)
+		}
+		var cells []string
+		for _, c := range row.Cells {
+			col := len(cells)
+			if c.R != "" {
+				var err error
+				if col, err = columnIndex(c.R); err != nil {
+					return nil, err
+				}
+			}
+			if col >= maxSheetColumns {
+				return nil, fmt.Errorf("row %d has more than %d columns", idx+1, maxSheetColumns)
+			}
+			var v string
+			switch c.T {
+			case "s":
+				if i, err := strconv.Atoi(c.V); err == nil && i >= 0 && i < len(shared) {
+					v = shared[i]
+				}
+			case "inlineStr":
+				v = c.Inline.String()
+			case "b":
+				v = map[string]string{"0": "FALSE", "1": "TRUE"}[c.V]
+			default:
+				v = c.V
+			}
+			if v == "" || col < len(cells) {
+				continue
+			}
+			for len(cells) < col {
+				cells = append(cells, "")
+			}
+			cells = append(cells, v)
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		width = max(width, len(cells))
+		if (idx+1)*width > maxSheetCells {
+			return nil, fmt.Errorf("sheet spans more than %d cells", maxSheetCells)
+		}
+		for len(rows) < idx {
+			rows = append(rows, []string{""})
+		}
+		rows = append(rows, cells)
+	}
+	// Pad rows so every CSV record has the same number of fields.
+	for i := range rows {
+		for len(rows[i]) < width {
+			rows[i] = append(rows[i], "")
+		}
+	}
+	return rows, nil
+}
+
+// columnIndex returns the zero-based column of a cell reference like "AB12".
+// Columns past XFD, Excel's last, are an error.
+func columnIndex(ref string) (int, error) {
+	col := 0
+	for _, r := range strings.ToUpper(ref) {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		if col > maxSheetColumns {
+			return 0, fmt.Errorf("cell %s is past column %d", ref, maxSheetColumns)
+		}
+	}
+	return col - 1, nil
+}
+
+// safeFileName makes a worksheet name or page title usable in a file name.
+func safeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" {
+		return "sheet"
+	}
+	return s
+}
+
+// convertPPTX renders the text of each slide, in presentation order, as
+// markdown.
+func convertPPTX(name string, data []byte) ([]attachment, error) {
+	parts, err := openZipParts(data)
+	if err != nil {
+		return nil, err
+	}
+	pres, err := parts.read("ppt/presentation.xml")
+	if err != nil {
+		return nil, err
+	}
+	rels, err := parts.relationships("ppt/presentation.xml")
+	if err != nil {
+		return nil, err
+	}
+	var slides []string
+	d := xml.NewDecoder(bytes.NewReader(pres))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ppt/presentation.xml: %w", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "sldId" {
+			if target, ok := rels[relID(se.Attr)]; ok {
+				slides = append(slides, target)
+			}
+		}
+	}
+
+	var out strings.Builder
+	for i, slide := range slides {
+		raw, err := parts.read(slide)
+		if err != nil {
+			return nil, err
+		}
+		paras, err := slideParagraphs(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", slide, err)
+		}
+		fmt.Fprintf(&out, "## Slide %d\n\n", i+1)
+		for _, p := range paras {
+			out.WriteString(p)
+			out.WriteString("\n\n")
+		}
+	}
+	return []attachment{textAttachment(name, ".md", strings.TrimSpace(out.String())+"\n")}, nil
+}
+
+// slideParagraphs returns the non-empty text paragraphs of a slide.
+func slideParagraphs(data []byte) ([]string, error) {
+	var (
+		paras  []string
+		para   strings.Builder
+		inText bool
+	)
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return paras, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				para.Reset()
+			case "t":
+				inText = true
+			case "br":
+				para.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if text := strings.TrimSpace(para.String()); text != "" {
+					paras = append(paras, text)
+				}
+			}
+		}
+	}
+}
+
+// notebookText is a notebook string field, stored either as one string or
+// as a list of lines.
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = notebookText(s)
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return err
+	}
+	*t = notebookText(strings.Join(lines, ""))
+	return nil
+}
+
+// convertIPYNB renders a Jupyter notebook as markdown: markdown cells as they
+// are, code cells as fenced blocks followed by their text outputs.
+func convertIPYNB(name string, data []byte) ([]attachment, error) {
+	var nb struct {
+		Cells []struct {
+			CellType string       `json:"cell_type"`
+			Source   notebookText `json:"source"`
+			Outputs  []struct {
+				OutputType string                     `json:"output_type"`
+				Text       notebookText               `json:"text"`
+				Data       map[string]json.RawMessage `json:"data"`
+				Ename      string                     `json:"ename"`
+				Evalue     string                     `json:"evalue"`
+			} `json:"outputs"`
+		} `json:"cells"`
+		Metadata struct {
+			LanguageInfo struct {
+				Name string `json:"name"`
+			} `json:"language_info"`
+			Kernelspec struct {
+				Language string `json:"language"`
+			} `json:"kernelspec"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, fmt.Errorf("invalid notebook: %w", err)
+	}
+	lang := nb.Metadata.LanguageInfo.Name
+	if lang == "" {
+		lang = nb.Metadata.Kernelspec.Language
+	}
+
+	var out strings.Builder
+	for _, cell := range nb.Cells {
+		src := strings.TrimRight(string(cell.Source), "\n")
+		switch cell.CellType {
+		case "code":
+			if src == "" && len(cell.Outputs) == 0 {
+				continue
+			}
+			out.WriteString(codeFence(lang, src))
+			out.WriteString("\n\n")
+			var outputs []string
+			for _, o := range cell.Outputs {
+				switch o.OutputType {
+				case "stream":
+					outputs = append(outputs, withNewline(string(o.Text)))
+				case "execute_result", "display_data":
+					var text notebookText
+					if raw, ok := o.Data["text/plain"]; ok && json.Unmarshal(raw, &text) == nil {
+						outputs = append(outputs, withNewline(string(text)))
+					} else if len(o.Data) > 0 {
+						outputs = append(outputs, "[non-text output omitted]\n")
+					}
+				case "error":
+					outputs = append(outputs, o.Ename+": "+o.Evalue+"\n")
+				}
+			}
+			if len(outputs) > 0 {
+				out.WriteString("Output:\n\n")
+				out.WriteString(codeFence("", strings.TrimRight(strings.Join(outputs, ""), "\n")))
+				out.WriteString("\n\n")
+			}
+		default: // markdown and raw cells
+			if src != "" {
+				out.WriteString(src)
+				out.WriteString("\n\n")
+			}
+		}
+	}
+	return []attachment{textAttachment(name, ".md", strings.TrimSpace(out.String())+"\n")}, nil
+}
+
+func withNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
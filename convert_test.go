@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeZip builds an Office package from part names and contents.
+func makeZip(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const docxXML = `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:body>
+<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Quarterly report</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">Revenue grew </w:t></w:r><w:r><w:t>12%.</w:t></w:r></w:p>
+<w:p><w:pPr><w:numPr><w:ilvl w:val="0"/></w:numPr></w:pPr><w:r><w:t>First point</w:t></w:r></w:p>
+<w:tbl>
+<w:tr><w:tc><w:p><w:r><w:t>Region</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Sales</w:t></w:r></w:p></w:tc></w:tr>
+<w:tr><w:tc><w:p><w:r><w:t>EU|West</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>42</w:t></w:r></w:p></w:tc></w:tr>
+</w:tbl>
+<w:p><w:r><w:t>The end</w:t></w:r></w:p>
+</w:body>
+</w:document>`
+
+func TestConvertDOCX(t *testing.T) {
+	data := makeZip(t, map[string]string{"word/document.xml": docxXML})
+	got, err := convertDOCX("report.docx", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "report.docx.md" {
+		t.Fatalf("got %+v, want one report.docx.md", got)
+	}
+	want := "# Quarterly report\n\nRevenue grew 12%.\n\n- First point\n\n" +
+		"| Region | Sales |\n| --- | --- |\n| EU\\|West | 42 |\n\nThe end\n"
+	if text := string(got[0].Data); text != want {
+		t.Errorf("text =\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestConvertDOCXTabs(t *testing.T) {
+	// Tab stops in the paragraph properties are layout, not text.
+	doc := `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:pPr><w:tabs><w:tab w:val="left" w:pos="720"/><w:tab w:val="right" w:pos="9000"/></w:tabs></w:pPr><w:r><w:t>Name</w:t><w:tab/><w:t>Value</w:t></w:r></w:p>
+</w:body></w:document>`
+	got, err := convertDOCX("tabs.docx", makeZip(t, map[string]string{"word/document.xml": doc}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := string(got[0].Data); text != "Name\tValue\n" {
+		t.Errorf("text = %q, want %q", text, "Name\tValue\n")
+	}
+}
+
+func TestConvertXLSX(t *testing.T) {
+	data := makeZip(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Q1/Sales" sheetId="1" r:id="rId1"/><sheet name="Empty" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Name</t></si><si><r><t>Amount, </t></r><r><t>USD</t></r></si><si><t>Widget</t></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="3"><c r="A3" t="s"><v>2</v></c><c r="C3"><v>9.5</v></c></row>
+<row r="4"><c r="A4" t="inlineStr"><is><t>Gadget</t></is></c><c r="B4" t="b"><v>1</v></c></row>
+</sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`,
+	})
+	got, err := convertXLSX("book.xlsx", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "book.xlsx.Q1_Sales.csv" {
+		t.Fatalf("got %+v, want one book.xlsx.Q1_Sales.csv", got)
+	}
+	want := "Name,\"Amount, USD\",\n,,\nWidget,,9.5\nGadget,TRUE,\n"
+	if text := string(got[0].Data); text != want {
+		t.Errorf("csv =\n%q\nwant\n%q", text, want)
+	}
+}
+
+func TestSheetRowsSparse(t *testing.T) {
+	sheet := func(cells string) []byte {
+		return []byte(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + cells + `</sheetData></worksheet>`)
+	}
+	rows, err := sheetRows(sheet(`<row r="1"><c r="A1"><v>1</v></c></row><row r="1000"><c r="C1000"><v>2</v></c></row>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1000 || len(rows[0]) != 3 || rows[999][2] != "2" {
+		t.Errorf("got %d rows of %d cells, want 1000 of 3", len(rows), len(rows[0]))
+	}
+
+	for name, cells := range map[string]string{
+		"corners":      `<row r="1"><c r="A1"><v>1</v></c></row><row r="1048576"><c r="XFD1048576"><v>2</v></c></row>`,
+		"past XFD":     `<row r="1"><c r="XFE1"><v>1</v></c></row>`,
+		"overflow":     `<row r="1"><c r="ZZZZZZZZZZZZZZ1"><v>1</v></c></row>`,
+		"past the end": `<row r="1048577"><c r="A1048577"><v>1</v></c></row>`,
+	} {
+		if _, err := sheetRows(sheet(cells), nil); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestConvertPPTX(t *testing.T) {
+	slide := func(texts ...string) string {
+		var sb strings.Builder
+		sb.WriteString(`<p:sld xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"><p:cSld><p:spTree>`)
+		for _, s := range texts {
+			sb.WriteString(`<p:sp><p:txBody><a:p><a:r><a:t>` + s + `</a:t></a:r></a:p></p:txBody></p:sp>`)
+		}
+		sb.WriteString(`</p:spTree></p:cSld></p:sld>`)
+		return sb.String()
+	}
+	data := makeZip(t, map[string]string{
+		"ppt/presentation.xml": `<p:presentation xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<p:sldIdLst><p:sldId id="256" r:id="rId3"/><p:sldId id="257" r:id="rId2"/></p:sldIdLst></p:presentation>`,
+		"ppt/_rels/presentation.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId2" Target="slides/slide2.xml"/><Relationship Id="rId3" Target="slides/slide1.xml"/></Relationships>`,
+		"ppt/slides/slide1.xml": slide("Welcome", "Agenda"),
+		"ppt/slides/slide2.xml": slide("Thanks"),
+	})
+	got, err := convertPPTX("deck.pptx", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "## Slide 1\n\nWelcome\n\nAgenda\n\n## Slide 2\n\nThanks\n"
+	if len(got) != 1 || got[0].Name != "deck.pptx.md" || string(got[0].Data) != want {
+		t.Errorf("got %s %q, want deck.pptx.md %q", got[0].Name, got[0].Data, want)
+	}
+}
+
+func TestConvertIPYNB(t *testing.T) {
+	nb := `{
+ "cells": [
+  {"cell_type": "markdown", "source": ["# Analysis\n", "Load the data."]},
+  {"cell_type": "code", "source": "print('hi')\n1 + 1", "outputs": [
+   {"output_type": "stream", "name": "stdout", "text": ["hi\n"]},
+   {"output_type": "execute_result", "data": {"text/plain": ["2"]}}
+  ]},
+  {"cell_type": "code", "source": ["plot()"], "outputs": [
+   {"output_type": "display_data", "data": {"image/png": "iVBOR"}},
+   {"output_type": "error", "ename": "ValueError", "evalue": "bad"}
+  ]}
+ ],
+ "metadata": {"language_info": {"name": "python"}}
+}`
+	got, err := convertIPYNB("analysis.ipynb", []byte(nb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Analysis\nLoad the data.\n\n" +
+		"```python\nprint('hi')\n1 + 1\n```\n\nOutput:\n\n```\nhi\n2\n```\n\n" +
+		"```python\nplot()\n```\n\nOutput:\n\n```\n[non-text output omitted]\nValueError: bad\n```\n"
+	if text := string(got[0].Data); text != want {
+		t.Errorf("text =\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestConvertDocuments(t *testing.T) {
+	dir := t.TempDir()
+	docx := filepath.Join(dir, "report.docx")
+	if err := os.WriteFile(docx, makeZip(t, map[string]string{"word/document.xml": docxXML}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	atts := []attachment{
+		{Source: docx, Name: "report.docx", Path: docx},
+		{Source: "notes.ipynb", Name: "notes.ipynb", Data: []byte(`{"cells": []}`)},
+		{Source: "https://example.com/a.docx", Name: "a.docx", Path: "https://example.com/a.docx"},
+	}
+
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{nil, []string{"report.docx", "notes.ipynb", "a.docx"}},
+		{[]string{"*"}, []string{"report.docx.md", "notes.ipynb.md", "a.docx"}},
+		{[]string{"*.docx"}, []string{"report.docx.md", "notes.ipynb", "a.docx"}},
+		{[]string{"notes.ipynb"}, []string{"report.docx", "notes.ipynb.md", "a.docx"}},
+		{[]string{docx}, []string{"report.docx.md", "notes.ipynb", "a.docx"}},
+	}
+	for _, tt := range tests {
+		got, err := convertDocuments(atts, tt.patterns)
+		if err != nil {
+			t.Fatalf("convertDocuments(%q): %v", tt.patterns, err)
+		}
+		var names []string
+		for _, a := range got {
+			names = append(names, a.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("convertDocuments(%q) = %v, want %v", tt.patterns, names, tt.want)
+		}
+	}
+}
+
+func TestConvertDocuments_Invalid(t *testing.T) {
+	atts := []attachment{{Source: "bad.docx", Name: "bad.docx", Data: []byte("not a zip")}}
+	if _, err := convertDocuments(atts, []string{"*"}); err == nil || !strings.Contains(err.Error(), "bad.docx") {
+		t.Errorf("err = %v, want a conversion error naming the file", err)
+	}
+}
+
+func TestConvertDocuments_TooLarge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "huge.docx")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sparse file: its size is checked before anything is read.
+	if err := f.Truncate(maxConvertFileBytes + 1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	atts := []attachment{{Source: path, Name: "huge.docx", Path: path}}
+	if _, err := convertDocuments(atts, []string{"*"}); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("err = %v, want a size limit error", err)
+	}
+}
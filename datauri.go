@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+// maxDataBytes is the largest decoded data: URI or base64 attachment.
+const maxDataBytes = 10 * 1024 * 1024
+
+// FileInput is an entry of QueryBotArgs.Files. In JSON it is either a string
+// (a local path, directory, glob pattern, URL or data: URI) or an object with
+// base64 content, which lets remote clients attach files they cannot share by
+// path.
+type FileInput struct {
+	Path     string // string form
+	Name     string
+	MIMEType string
+	Base64   string
+}
+
+type fileInputObject struct {
+	Name     string `json:"name,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Base64   string `json:"base64"`
+}
+
+// fileInputSchema describes FileInput, which schema inference cannot.
+var fileInputSchema = &jsonschema.Schema{
+	OneOf: []*jsonschema.Schema{
+		{Type: "string", Description: "Local path, directory, glob pattern, URL or data: URI"},
+		{
+			Type:        "object",
+			Description: "File content encoded as base64",
+			Properties: map[string]*jsonschema.Schema{
+				"name":      {Type: "string", Description: "File name, e.g. screenshot.png"},
+				"mime_type": {Type: "string", Description: "MIME type, e.g. image/png"},
+				"base64":    {Type: "string", Description: "Base64-encoded file content"},
+			},
+			Required: []string{"base64"},
+		},
+	},
+}
+
+// inputSchemaFor infers the input schema of a tool whose arguments contain
+// FileInput fields.
+func inputSchemaFor[T any]() (*jsonschema.Schema, error) {
+	return jsonschema.For[T](&jsonschema.ForOptions{
+		TypeSchemas: map[reflect.Type]*jsonschema.Schema{
+			reflect.TypeFor[FileInput](): fileInputSchema,
+		},
+	})
+}
+
+func (f *FileInput) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*f = FileInput{Path: s}
+		return nil
+	}
+	var obj fileInputObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("file entry must be a string or an object with base64 content")
+	}
+	if obj.Base64 == "" {
+		return fmt.Errorf("file object %q has no base64 content", obj.Name)
+	}
+	if obj.MIMEType != "" {
+		mediaType, err := parseMediaType(obj.MIMEType)
+		if err != nil {
+			return fmt.Errorf("file object %q: %w", obj.Name, err)
+		}
+		obj.MIMEType = mediaType
+	}
+	*f = FileInput{Name: obj.Name, MIMEType: obj.MIMEType, Base64: obj.Base64}
+	return nil
+}
+
+// parseMediaType validates a type/subtype media type without parameters and
+// returns it in lower case. Parameters would be spliced into the data: URI
+// built by FileInput.entry, so they are rejected.
+func parseMediaType(s string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(s)
+	if err != nil || len(params) > 0 || strings.Count(mediaType, "/") != 1 {
+		return "", fmt.Errorf("invalid MIME type %q", s)
+	}
+	return mediaType, nil
+}
+
+func (f FileInput) MarshalJSON() ([]byte, error) {
+	if f.Base64 == "" {
+		return json.Marshal(f.Path)
+	}
+	return json.Marshal(fileInputObject{Name: f.Name, MIMEType: f.MIMEType, Base64: f.Base64})
+}
+
+// entry returns the string form of f used by prepareAttachments; objects are
+// turned into data: URIs carrying their name.
+func (f FileInput) entry() string {
+	if f.Base64 == "" {
+		return f.Path
+	}
+	var sb strings.Builder
+	sb.WriteString("data:")
+	sb.WriteString(f.MIMEType)
+	if f.Name != "" {
+		sb.WriteString(";name=")
+		sb.WriteString(url.PathEscape(f.Name))
+	}
+	sb.WriteString(";base64,")
+	sb.WriteString(f.Base64)
+	return sb.String()
+}
+
+// isDataURI reports whether s is a data: URI.
+func isDataURI(s string) bool {
+	return len(s) >= 5 && strings.EqualFold(s[:5], "data:")
+}
+
+// decodeDataURI decodes an RFC 2397 data: URI into an in-memory attachment.
+// A non-standard "name" parameter sets the file name; a missing extension is
+// derived from the media type, which is sniffed from the content when the
+// URI has none.
+func decodeDataURI(s string) (attachment, error) {
+	header, payload, ok := strings.Cut(s[len("data:"):], ",")
+	if !ok {
+		return attachment{}, fmt.Errorf("invalid data URI: missing ','")
+	}
+
+	var mediaType, name string
+	isBase64 := false
+	for i, part := range strings.Split(header, ";") {
+		part = strings.TrimSpace(part)
+		switch {
+		case strings.EqualFold(part, "base64"):
+			isBase64 = true
+		case strings.HasPrefix(strings.ToLower(part), "name="):
+			name, _ = url.PathUnescape(part[len("name="):])
+		case i == 0 && part != "" && !strings.Contains(part, "="):
+			var err error
+			if mediaType, err = parseMediaType(part); err != nil {
+				return attachment{}, fmt.Errorf("invalid data URI: %w", err)
+			}
+		}
+	}
+	if name == "" {
+		name = "attachment"
+	}
+	source := "data URI " + name
+	if mediaType != "" {
+		source += " (" + mediaType + ")"
+	}
+
+	var data []byte
+	if isBase64 {
+		// Check the encoded size first to avoid decoding oversized payloads.
+		if base64.StdEncoding.DecodedLen(len(payload)) > maxDataBytes+3 {
+			return attachment{}, fmt.Errorf("%s exceeds the %d byte limit", source, maxDataBytes)
+		}
+		var err error
+		if data, err = decodeBase64(payload); err != nil {
+			return attachment{}, fmt.Errorf("%s: invalid base64: %w", source, err)
+		}
+	} else {
+		text, err := url.PathUnescape(payload)
+		if err != nil {
+			return attachment{}, fmt.Errorf("%s: %w", source, err)
+		}
+		data = []byte(text)
+	}
+	if len(data) > maxDataBytes {
+		return attachment{}, fmt.Errorf("%s exceeds the %d byte limit", source, maxDataBytes)
+	}
+	if len(data) == 0 {
+		return attachment{}, fmt.Errorf("%s is empty", source)
+	}
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+		source += " (" + mediaType + ")"
+	}
+	if filepath.Ext(name) == "" {
+		name += extensionForMIME(mediaType)
+	}
+	return attachment{Source: source, Name: name, Data: data}, nil
+}
+
+// decodeBase64 accepts standard and URL-safe base64, padded or not, ignoring
+// whitespace and line breaks.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	enc := base64.StdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.URLEncoding
+	}
+	if !strings.HasSuffix(s, "=") && len(s)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	return enc.DecodeString(s)
+}
+
+// extensionForMIME returns a file extension for mediaType, or "" if unknown.
+func extensionForMIME(mediaType string) string {
+	switch mediaType {
+	case "text/plain":
+		return ".txt"
+	case "image/jpeg":
+		return ".jpg"
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestDecodeDataURI(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString(pngHeader)
+	tests := []struct {
+		uri      string
+		wantName string
+		wantData string
+	}{
+		{"data:image/png;base64," + png, "attachment.png", string(pngHeader)},
+		{"data:image/png;name=shot%201.png;base64," + png, "shot 1.png", string(pngHeader)},
+		{"data:image/png;name=screenshot;base64," + png, "screenshot.png", string(pngHeader)},
+		{"data:,hello%20world", "attachment.txt", "hello world"},
+		{"data:text/plain;base64,aGVsbG8", "attachment.txt", "hello"}, // unpadded
+		// Without a media type the content decides the extension.
+		{"data:;base64," + png, "attachment.png", string(pngHeader)},
+		{"data:base64," + png, "attachment.png", string(pngHeader)},
+		{"data:;name=shot;base64," + png, "shot.png", string(pngHeader)},
+		{"data:;base64," + base64.StdEncoding.EncodeToString([]byte("%PDF-1.7\n")), "attachment.pdf", "%PDF-1.7\n"},
+	}
+	for _, tt := range tests {
+		a, err := decodeDataURI(tt.uri)
+		if err != nil {
+			t.Errorf("decodeDataURI(%q): %v", tt.uri, err)
+			continue
+		}
+		if a.Name != tt.wantName || string(a.Data) != tt.wantData {
+			t.Errorf("decodeDataURI(%q) = %q %q, want %q %q", tt.uri, a.Name, a.Data, tt.wantName, tt.wantData)
+		}
+	}
+
+	for _, bad := range []string{
+		"data:image/png;base64",
+		"data:image/png;base64,!!!",
+		"data:text/plain;base64,",
+		"data:text/plain;base64," + strings.Repeat("A", maxDataBytes*4/3+8),
+		"data:image;base64," + png,
+		"data:image/png/x;base64," + png,
+	} {
+		if _, err := decodeDataURI(bad); err == nil {
+			t.Errorf("decodeDataURI(%.40q) expected error", bad)
+		}
+	}
+}
+
+func TestFileInputJSON(t *testing.T) {
+	var files []FileInput
+	data := `["notes.txt", {"name": "shot.png", "mime_type": "image/png", "base64": "iVBORw=="}]`
+	if err := json.Unmarshal([]byte(data), &files); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if files[0].entry() != "notes.txt" {
+		t.Errorf("string entry = %q", files[0].entry())
+	}
+	if got := files[1].entry(); got != "data:image/png;name=shot.png;base64,iVBORw==" {
+		t.Errorf("object entry = %q", got)
+	}
+	out, err := json.Marshal(files)
+	if err != nil || string(out) != `["notes.txt",{"name":"shot.png","mime_type":"image/png","base64":"iVBORw=="}]` {
+		t.Errorf("marshal = %s, %v", out, err)
+	}
+
+	if err := json.Unmarshal([]byte(`[{"mime_type": "Image/PNG", "base64": "iVBORw=="}]`), &files); err != nil || files[0].MIMEType != "image/png" {
+		t.Errorf("mime_type not normalised: %+v, %v", files, err)
+	}
+
+	for _, bad := range []string{
+		`[42]`,
+		`[{"name": "x.png"}]`,
+		`[{"mime_type": "image/png,", "base64": "iVBORw=="}]`,
+		`[{"mime_type": "image/png;name=evil.exe", "base64": "iVBORw=="}]`,
+		`[{"mime_type": "png", "base64": "iVBORw=="}]`,
+	} {
+		if err := json.Unmarshal([]byte(bad), &files); err == nil {
+			t.Errorf("unmarshal %s expected error", bad)
+		}
+	}
+}
+
+func TestPrepareAttachmentsDataURI(t *testing.T) {
+	f := FileInput{Name: "notes", MIMEType: "text/plain", Base64: base64.StdEncoding.EncodeToString([]byte("hi"))}
+	atts, err := prepareAttachments([]string{f.entry()}, uploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(atts) != 1 || atts[0].Name != "notes.txt" || string(atts[0].Data) != "hi" {
+		t.Errorf("attachments = %+v", atts)
+	}
+}
+
+func TestQueryBotAcceptsFileObjects(t *testing.T) {
+	origKey := apiKey
+	defer func() { apiKey = origKey }()
+	apiKey = ""
+
+	ctx := context.Background()
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, nil)
+	registerQueryBot(server)
+	st, ct := mcp.NewInMemoryTransports()
+	ss, err := server.Connect(ctx, st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "0.0.0"}, nil).Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+
+	// The call passes schema validation and fails on the missing API key.
+	res, err := cs.CallTool(ctx, &mcp.CallToolParams{
+		Name: "query_bot",
+		Arguments: map[string]any{
+			"bot":     "GPT-4o",
+			"message": "hi",
+			"files":   []any{"notes.txt", map[string]any{"name": "shot.png", "base64": "iVBORw=="}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	text := res.Content[0].(*mcp.TextContent).Text
+	if !res.IsError || !strings.Contains(text, "POE_API_KEY") {
+		t.Errorf("result = %q, want POE_API_KEY error", text)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxFiles = 100
+	defaultMaxBytes = 20 * 1024 * 1024
+
+	// bundleFileLimit is the largest text file that is bundled rather than
+	// uploaded on its own.
+	bundleFileLimit = 32 * 1024
+
+	// defaultStdinName names an attachment read from stdin.
+	defaultStdinName = "stdin.txt"
+)
+
+// attachment is a file to send to a bot: a local path, a URL, or content that
+// is already in memory.
+type attachment struct {
+	Source string // what the user asked for, used in messages
+	Name   string // file name shown to the bot
+	Path   string // local path or URL; empty when Data is set
+	Data   []byte // in-memory content
+}
+
+// hasGlobMeta reports whether s contains glob metacharacters.
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// expansionBudget tracks the files and bytes added by glob and directory
+// expansion across all entries of a request.
+type expansionBudget struct {
+	maxFiles, maxBytes int
+	files, bytes       int
+}
+
+func (b *expansionBudget) add(entry string, size int64) error {
+	b.files++
+	b.bytes += int(size)
+	if b.files > b.maxFiles {
+		return fmt.Errorf("%q expands to more than %d files; narrow the pattern or raise max_files", entry, b.maxFiles)
+	}
+	if b.bytes > b.maxBytes {
+		return fmt.Errorf("%q expands to more than %d bytes; narrow the pattern or raise max_bytes", entry, b.maxBytes)
+	}
+	return nil
+}
+
+// prepareAttachments turns the user's file list into attachments. URLs and
+// plain paths are passed through, data: URIs are decoded, and "-" becomes
+// opts.Stdin; directories and glob patterns (including "**") are expanded,
+// skipping files excluded by .gitignore or by opts.Sandbox. Small text files
+// found by one expansion are bundled into a single text attachment with a
+// header per file, so a whole package costs one upload. Bundling is skipped
+// when text files are inlined, so each file keeps its own labelled block.
+//
+// An entry that cannot be prepared does not stop the others: the attachments
+// of the rest are returned with an *uploadError listing the failed entries.
+func prepareAttachments(files []string, opts uploadOptions) ([]attachment, error) {
+	budget := &expansionBudget{maxFiles: opts.MaxFiles, maxBytes: opts.MaxBytes}
+	if budget.maxFiles <= 0 {
+		budget.maxFiles = defaultMaxFiles
+	}
+	if budget.maxBytes <= 0 {
+		budget.maxBytes = defaultMaxBytes
+	}
+
+	var (
+		out       []attachment
+		failures  []fileFailure
+		stdinUsed bool
+	)
+	fail := func(entry string, err error) {
+		failures = append(failures, fileFailure{Path: entry, Err: err})
+	}
+	for _, entry := range files {
+		if entry == "-" {
+			if opts.Stdin == nil {
+				fail(entry, fmt.Errorf("stdin is not available for attachments here"))
+				continue
+			}
+			if stdinUsed {
+				fail(entry, fmt.Errorf("stdin can only be attached once"))
+				continue
+			}
+			stdinUsed = true
+			name := opts.StdinName
+			if name == "" {
+				name = defaultStdinName
+			}
+			out = append(out, attachment{Source: "stdin", Name: name, Data: opts.Stdin})
+			continue
+		}
+		if isDataURI(entry) {
+			a, err := decodeDataURI(entry)
+			if err != nil {
+				fail(entry, err)
+				continue
+			}
+			out = append(out, a)
+			continue
+		}
+		if isURL(entry) {
+			out = append(out, attachment{Source: entry, Name: urlFileName(entry), Path: entry})
+			continue
+		}
+
+		var found []attachment
+		var root string
+		var err error
+		if hasGlobMeta(entry) {
+			root, found, err = expandGlob(entry, budget, opts.Sandbox)
+		} else {
+			// Check the sandbox before touching the path, so a refused path
+			// is never reported as missing.
+			p, resolveErr := opts.Sandbox.resolve(entry)
+			if resolveErr != nil {
+				fail(entry, resolveErr)
+				continue
+			}
+			if info, statErr := os.Stat(p); statErr != nil || !info.IsDir() {
+				// Missing files are reported per file at upload time.
+				out = append(out, attachment{Source: entry, Name: filepath.Base(entry), Path: p})
+				continue
+			}
+			root = entry
+			found, err = walkDir(entry, entry, "", budget, opts.Sandbox)
+		}
+		if err != nil {
+			fail(entry, err)
+			continue
+		}
+		if len(found) == 0 {
+			fail(entry, fmt.Errorf("%q matched no files", entry))
+			continue
+		}
+		if opts.Mode == attachInline || opts.Mode == attachAuto {
+			out = append(out, found...)
+			continue
+		}
+		out = append(out, bundleSmallTextFiles(entry, root, found)...)
+	}
+	if len(failures) > 0 {
+		return out, &uploadError{Failures: failures, Total: len(files)}
+	}
+	return out, nil
+}
+
+// expandGlob walks the static directory prefix of pattern and returns the
+// files matching it.
+func expandGlob(pattern string, budget *expansionBudget, sb *sandbox) (string, []attachment, error) {
+	slashed := filepath.ToSlash(pattern)
+	segs := strings.Split(slashed, "/")
+	i := 0
+	for i < len(segs) && !hasGlobMeta(segs[i]) {
+		i++
+	}
+	root := strings.Join(segs[:i], "/")
+	if root == "" {
+		if strings.HasPrefix(slashed, "/") {
+			root = "/"
+		} else {
+			root = "."
+		}
+	}
+	root = filepath.FromSlash(root)
+	rest := strings.Join(segs[i:], "/")
+
+	resolved, err := sb.resolve(root)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := os.Stat(resolved); err != nil {
+		return "", nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	found, err := walkDir(pattern, root, rest, budget, sb)
+	return root, found, err
+}
+
+// walkDir lists regular files under root, optionally filtered by a glob
+// pattern relative to root, honouring .gitignore files and always skipping
+// .git directories. Files the sandbox refuses are skipped, not reported. Each
+// attachment's Path is the resolved path the sandbox approved, so that is the
+// file read later; Source keeps the path under root as given. A symlinked
+// root is followed, since filepath.WalkDir would not descend into it.
+func walkDir(entry, root, pattern string, budget *expansionBudget, sb *sandbox) ([]attachment, error) {
+	walkRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	ign := newGitignore()
+	ign.loadAncestors(root)
+
+	var found []attachment
+	err = filepath.WalkDir(walkRoot, func(wp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(walkRoot, wp)
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(root, rel)
+		if d.IsDir() {
+			if wp != walkRoot && (d.Name() == ".git" || ign.ignored(p, true) || sb.denies(wp)) {
+				return filepath.SkipDir
+			}
+			ign.load(p)
+			return nil
+		}
+		if !d.Type().IsRegular() || ign.ignored(p, false) {
+			return nil
+		}
+		resolved, err := sb.resolve(wp)
+		if err != nil {
+			return nil
+		}
+		if pattern != "" && !matchGlob(pattern, filepath.ToSlash(rel)) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := budget.add(entry, info.Size()); err != nil {
+			return err
+		}
+		found = append(found, attachment{Source: p, Name: filepath.Base(p), Path: resolved})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// bundleSmallTextFiles returns the found files, except that small UTF-8 text
+// files are combined into one bundle when there are at least two of them.
+func bundleSmallTextFiles(entry, root string, found []attachment) []attachment {
+	var out []attachment
+	var bundle []attachment
+	texts := make(map[string][]byte)
+	for _, a := range found {
+		if data, ok := readSmallText(a.Path); ok {
+			bundle = append(bundle, a)
+			texts[a.Path] = data
+			continue
+		}
+		out = append(out, a)
+	}
+
+	if len(bundle) == 1 {
+		return append(out, bundle[0])
+	}
+	if len(bundle) > 1 {
+		var sb strings.Builder
+		for i, a := range bundle {
+			if i > 0 {
+				sb.WriteString("\n")
+			}
+			fmt.Fprintf(&sb, "===== %s =====\n", filepath.ToSlash(a.Source))
+			sb.Write(texts[a.Path])
+			if !strings.HasSuffix(sb.String(), "\n") {
+				sb.WriteString("\n")
+			}
+		}
+		out = append(out, attachment{
+			Source: fmt.Sprintf("%s (%d files bundled)", entry, len(bundle)),
+			Name:   bundleName(root),
+			Data:   []byte(sb.String()),
+		})
+	}
+	return out
+}
+
+// bundleName names a bundle after the directory it was collected from.
+func bundleName(root string) string {
+	base := filepath.Base(root)
+	if abs, err := filepath.Abs(root); err == nil {
+		base = filepath.Base(abs)
+	}
+	if base == "" || base == "." || base == string(filepath.Separator) {
+		base = "files"
+	}
+	return base + ".bundle.txt"
+}
+
+// readSmallText returns the content of p if it is a UTF-8 text file no larger
+// than bundleFileLimit.
+func readSmallText(p string) ([]byte, bool) {
+	info, err := os.Stat(p)
+	if err != nil || info.Size() > bundleFileLimit {
+		return nil, false
+	}
+	data, err := os.ReadFile(p)
+	if err != nil || !utf8.Valid(data) || strings.ContainsRune(string(data), 0) {
+		return nil, false
+	}
+	return data, true
+}
+
+// urlFileName derives an attachment name from a URL.
+func urlFileName(u string) string {
+	name := filepath.Base(u)
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	return name
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files under root from a map of slash-separated paths to
+// contents.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "a/b/c.go", true},
+		{"src/**/*.go", "src/x/y.go", true},
+		{"src/**/*.go", "lib/x/y.go", false},
+		{"a/**", "a/b/c", true},
+		{"doc/*.md", "doc/sub/x.md", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":     "*.log\nbuild/\n/secret.txt\n!keep.log\n",
+		"sub/.gitignore": "!debug.log\n",
+		"app.log":        "",
+		"keep.log":       "",
+		"secret.txt":     "",
+		"sub/secret.txt": "",
+		"sub/debug.log":  "",
+		"sub/other.log":  "",
+		"build/out.bin":  "",
+	})
+
+	ign := newGitignore()
+	ign.load(root)
+	ign.load(filepath.Join(root, "sub"))
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"app.log", false, true},
+		{"keep.log", false, false},
+		{"secret.txt", false, true},
+		{"sub/secret.txt", false, false}, // anchored to root
+		{"sub/debug.log", false, false},  // re-included by nested file
+		{"sub/other.log", false, true},
+		{"build", true, true},
+		{"build", false, false}, // dir-only pattern
+	}
+	for _, tt := range tests {
+		p := filepath.Join(root, filepath.FromSlash(tt.path))
+		if got := ign.ignored(p, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestPrepareAttachmentsGlobAndBundle(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":         "vendor/\n",
+		"src/main.go":        "package main\n",
+		"src/util/util.go":   "package util",
+		"src/util/util.txt":  "not go",
+		"vendor/dep/dep.go":  "package dep\n",
+		".git/hooks/pre.go":  "package hooks\n",
+		"src/big/big.go":     strings.Repeat("x", bundleFileLimit+1),
+		"src/bin/binary.go":  "\x00\x01\x02",
+		"docs/readme.md":     "# Readme\n",
+		"docs/nested/a.md":   "a\n",
+		"docs/nested/b.json": "{}",
+	})
+
+	atts, err := prepareAttachments([]string{filepath.Join(root, "**", "*.go")}, uploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var bundle *attachment
+	var paths []string
+	for i, a := range atts {
+		if a.Data != nil {
+			bundle = &atts[i]
+			continue
+		}
+		paths = append(paths, filepath.ToSlash(strings.TrimPrefix(a.Path, root)))
+	}
+	if got := strings.Join(paths, ","); got != "/src/big/big.go,/src/bin/binary.go" {
+		t.Errorf("separate uploads = %q, want big and binary files only", got)
+	}
+	if bundle == nil {
+		t.Fatal("expected a bundle for small text files")
+	}
+	if bundle.Name != filepath.Base(root)+".bundle.txt" {
+		t.Errorf("bundle name = %q", bundle.Name)
+	}
+	content := string(bundle.Data)
+	for _, want := range []string{"src/main.go =====\npackage main\n", "src/util/util.go =====\npackage util\n"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("bundle missing %q\ngot: %s", want, content)
+		}
+	}
+	for _, unwanted := range []string{"vendor", ".git", "util.txt"} {
+		if strings.Contains(content, unwanted) {
+			t.Errorf("bundle should not contain %q\ngot: %s", unwanted, content)
+		}
+	}
+
+	// A directory expands recursively; a single small file is not bundled.
+	atts, err = prepareAttachments([]string{filepath.Join(root, "docs", "nested")}, uploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(atts) != 1 || atts[0].Name != "nested.bundle.txt" {
+		t.Errorf("directory attachments = %+v, want one nested.bundle.txt", atts)
+	}
+}
+
+func TestPrepareAttachmentsPassThrough(t *testing.T) {
+	atts, err := prepareAttachments([]string{"https://example.com/a.pdf", "/no/such/file.txt"}, uploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(atts) != 2 || atts[0].Name != "a.pdf" || atts[1].Path != "/no/such/file.txt" {
+		t.Errorf("attachments = %+v", atts)
+	}
+}
+
+func TestPrepareAttachmentsBudget(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt": "aaaa",
+		"b.txt": "bbbb",
+		"c.txt": "cccc",
+	})
+
+	_, err := prepareAttachments([]string{root}, uploadOptions{MaxFiles: 2})
+	if err == nil || !strings.Contains(err.Error(), "more than 2 files") {
+		t.Errorf("expected max files error, got %v", err)
+	}
+	_, err = prepareAttachments([]string{root}, uploadOptions{MaxBytes: 10})
+	if err == nil || !strings.Contains(err.Error(), "more than 10 bytes") {
+		t.Errorf("expected max bytes error, got %v", err)
+	}
+	_, err = prepareAttachments([]string{filepath.Join(root, "*.go")}, uploadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "matched no files") {
+		t.Errorf("expected no match error, got %v", err)
+	}
+}
+
+func TestPrepareAttachmentsStdin(t *testing.T) {
+	atts, err := prepareAttachments([]string{"-"}, uploadOptions{Stdin: []byte("diff"), StdinName: "diff.patch"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(atts) != 1 || atts[0].Name != "diff.patch" || string(atts[0].Data) != "diff" {
+		t.Errorf("stdin attachment = %+v", atts)
+	}
+
+	atts, _ = prepareAttachments([]string{"-"}, uploadOptions{Stdin: []byte("x")})
+	if atts[0].Name != defaultStdinName {
+		t.Errorf("default name = %q, want %q", atts[0].Name, defaultStdinName)
+	}
+
+	if _, err := prepareAttachments([]string{"-"}, uploadOptions{}); err == nil {
+		t.Error("expected error when stdin is not available")
+	}
+	if _, err := prepareAttachments([]string{"-", "-"}, uploadOptions{Stdin: []byte("x")}); err == nil {
+		t.Error("expected error when stdin is attached twice")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single pattern from a .gitignore file.
+type ignoreRule struct {
+	base     string // slash-separated directory containing the .gitignore
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool // pattern contains a slash and is relative to base
+}
+
+// gitignore evaluates .gitignore rules collected from a directory tree and its
+// ancestors. It supports the common subset of gitignore syntax: comments,
+// negation, trailing-slash directory patterns, anchored patterns and "**".
+type gitignore struct {
+	rules map[string][]ignoreRule // keyed by slash-separated directory
+}
+
+func newGitignore() *gitignore {
+	return &gitignore{rules: make(map[string][]ignoreRule)}
+}
+
+// loadAncestors loads .gitignore files from dir's parents up to the root of
+// the enclosing git repository, if there is one.
+func (g *gitignore) loadAncestors(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	var chain []string
+	for d := filepath.Dir(abs); ; d = filepath.Dir(d) {
+		chain = append(chain, d)
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			break
+		}
+		if filepath.Dir(d) == d {
+			// Not inside a repository: ancestor rules do not apply.
+			return
+		}
+	}
+	for _, d := range chain {
+		g.load(d)
+	}
+}
+
+// load reads dir/.gitignore if present.
+func (g *gitignore) load(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	base := filepath.ToSlash(abs)
+	if _, done := g.rules[base]; done {
+		return
+	}
+	g.rules[base] = nil
+
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		g.rules[base] = append(g.rules[base], r)
+	}
+}
+
+// ignored reports whether file (a path on disk) is excluded by the loaded
+// rules. Rules from deeper directories take precedence, and within a file the
+// last matching rule wins.
+func (g *gitignore) ignored(file string, isDir bool) bool {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	p := filepath.ToSlash(abs)
+
+	var dirs []string
+	for d := path.Dir(p); ; d = path.Dir(d) {
+		dirs = append(dirs, d)
+		if d == "/" || d == "." || path.Dir(d) == d {
+			break
+		}
+	}
+
+	ignored := false
+	// Walk from the outermost directory inwards so deeper rules override.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		for _, r := range g.rules[dirs[i]] {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if r.matches(p) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func (r ignoreRule) matches(p string) bool {
+	rel := strings.TrimPrefix(p, strings.TrimSuffix(r.base, "/")+"/")
+	if rel == p {
+		return false
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	return matchGlob(r.pattern, path.Base(rel))
+}
+
+// matchGlob matches a slash-separated path against a glob pattern in which
+// "**" matches any number of path segments, including none.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], segs[0]); err != nil || !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -3,12 +3,15 @@ module github.com/n0madic/poe-mcp
 go 1.23.0
 
 require (
+	github.com/google/jsonschema-go v0.4.2
 	github.com/modelcontextprotocol/go-sdk v1.3.0
 	github.com/n0madic/go-poe v0.0.0-20260308064535-d0900fb3c998
+	golang.org/x/image v0.30.0
+	golang.org/x/net v0.43.0
 )
 
 require (
-	github.com/google/jsonschema-go v0.4.2 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
 	golang.org/x/oauth2 v0.30.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 )
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register the WebP decoder
+)
+
+// jpegQualities are tried in order until an image fits the byte budget.
+var jpegQualities = []int{85, 75, 60}
+
+// minImageDimension stops downscaling once the longer side gets this small.
+const minImageDimension = 256
+
+// maxImagePixels is the largest image decoded for processing. Decoding
+// allocates memory for every pixel, so a small file declaring huge dimensions
+// is rejected from its header alone.
+const maxImagePixels = 64 * 1000 * 1000
+
+// ResizedImage reports an image that was downscaled or re-encoded before
+// upload.
+type ResizedImage struct {
+	Name           string `json:"name"`
+	NewName        string `json:"new_name"`
+	OriginalBytes  int    `json:"original_bytes"`
+	OriginalWidth  int    `json:"original_width"`
+	OriginalHeight int    `json:"original_height"`
+	Bytes          int    `json:"bytes"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+}
+
+func (r ResizedImage) String() string {
+	return fmt.Sprintf("%s: %dx%d %s -> %s %dx%d %s", r.Name,
+		r.OriginalWidth, r.OriginalHeight, formatBytes(r.OriginalBytes),
+		r.NewName, r.Width, r.Height, formatBytes(r.Bytes))
+}
+
+// formatBytes renders n as B, KB or MB.
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// resizeImages downscales and re-encodes local and in-memory images that
+// exceed opts.MaxImageDimension (longer side, in pixels) or opts.MaxImageBytes.
+// Images that fit, URLs, and files that cannot be decoded are left unchanged;
+// processed images become in-memory attachments, turned upright as their EXIF
+// orientation says. Images over maxImagePixels are an error.
+func resizeImages(atts []attachment, opts uploadOptions) ([]attachment, []ResizedImage, error) {
+	if opts.MaxImageDimension <= 0 && opts.MaxImageBytes <= 0 {
+		return atts, nil, nil
+	}
+	var resized []ResizedImage
+	out := make([]attachment, len(atts))
+	for i, a := range atts {
+		out[i] = a
+		if a.Data == nil && (a.Path == "" || isURL(a.Path)) {
+			continue
+		}
+		if mimeType, err := sniffMIME(a); err != nil || !strings.HasPrefix(mimeType, "image/") {
+			continue
+		}
+		data := a.Data
+		if data == nil {
+			var err error
+			if data, err = os.ReadFile(a.Path); err != nil {
+				continue // reported when the upload fails
+			}
+		}
+		if err := checkImagePixels(data); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", a.Source, err)
+		}
+		newData, info, ok := shrinkImage(data, a.Name, opts.MaxImageDimension, opts.MaxImageBytes)
+		if !ok {
+			continue
+		}
+		out[i] = attachment{Source: a.Source, Name: info.NewName, Data: newData}
+		resized = append(resized, info)
+	}
+	return out, resized, nil
+}
+
+// checkImagePixels rejects an image whose header declares more than
+// maxImagePixels. Data that is not a decodable image passes.
+func checkImagePixels(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return fmt.Errorf("image is %dx%d, over the %d megapixel limit for processing", cfg.Width, cfg.Height, maxImagePixels/1000/1000)
+	}
+	return nil
+}
+
+// shrinkImage re-encodes data if it exceeds maxDim or maxBytes (zero means no
+// limit). Opaque images are encoded as JPEG, others as PNG, after applying
+// the EXIF orientation, which re-encoding drops. If the byte budget still
+// cannot be met, the image is scaled down further until its longer side
+// reaches minImageDimension, and the smallest result is returned. Images over
+// maxImagePixels are not decoded.
+func shrinkImage(data []byte, name string, maxDim, maxBytes int) ([]byte, ResizedImage, bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || checkImagePixels(data) != nil {
+		return nil, ResizedImage{}, false
+	}
+	longest := max(cfg.Width, cfg.Height)
+	tooLarge := maxDim > 0 && longest > maxDim
+	tooHeavy := maxBytes > 0 && len(data) > maxBytes
+	if !tooLarge && !tooHeavy {
+		return nil, ResizedImage{}, false
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ResizedImage{}, false
+	}
+	img = applyOrientation(img, exifOrientation(data))
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	opaque := isOpaque(img)
+	ext := ".png"
+	if opaque {
+		ext = ".jpg"
+	}
+
+	target := longest
+	if tooLarge {
+		target = maxDim
+	}
+	var best []byte
+	var bestW, bestH int
+	for {
+		w, h := scaledSize(width, height, target)
+		scaled := img
+		if w != width || h != height {
+			dst := image.NewRGBA(image.Rect(0, 0, w, h))
+			draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+			scaled = dst
+		}
+		for _, enc := range encoders(opaque) {
+			buf, err := enc(scaled)
+			if err != nil {
+				return nil, ResizedImage{}, false
+			}
+			if best == nil || len(buf) < len(best) {
+				best, bestW, bestH = buf, w, h
+			}
+			if maxBytes <= 0 || len(buf) <= maxBytes {
+				return result(data, buf, name, ext, cfg, w, h)
+			}
+		}
+		if target <= minImageDimension {
+			return result(data, best, name, ext, cfg, bestW, bestH)
+		}
+		target = max(target*3/4, minImageDimension)
+	}
+}
+
+func result(orig, data []byte, name, ext string, cfg image.Config, w, h int) ([]byte, ResizedImage, bool) {
+	newName := strings.TrimSuffix(name, filepath.Ext(name)) + ext
+	return data, ResizedImage{
+		Name:           name,
+		NewName:        newName,
+		OriginalBytes:  len(orig),
+		OriginalWidth:  cfg.Width,
+		OriginalHeight: cfg.Height,
+		Bytes:          len(data),
+		Width:          w,
+		Height:         h,
+	}, true
+}
+
+// scaledSize fits w x h within a longer side of target, keeping the aspect
+// ratio.
+func scaledSize(w, h, target int) (int, int) {
+	longest := max(w, h)
+	if longest <= target {
+		return w, h
+	}
+	return max(1, w*target/longest), max(1, h*target/longest)
+}
+
+// encoders returns the encodings to try for an image, best quality first.
+func encoders(opaque bool) []func(image.Image) ([]byte, error) {
+	if !opaque {
+		return []func(image.Image) ([]byte, error){func(img image.Image) ([]byte, error) {
+			var buf bytes.Buffer
+			enc := png.Encoder{CompressionLevel: png.BestCompression}
+			err := enc.Encode(&buf, img)
+			return buf.Bytes(), err
+		}}
+	}
+	var out []func(image.Image) ([]byte, error)
+	for _, q := range jpegQualities {
+		out = append(out, func(img image.Image) ([]byte, error) {
+			var buf bytes.Buffer
+			err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q})
+			return buf.Bytes(), err
+		})
+	}
+	return out
+}
+
+// isOpaque reports whether img has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
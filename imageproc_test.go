@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tinyWebP is a 1x1 lossless WebP image.
+const tinyWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// noisyPNG encodes a w x h image of random pixels, which compresses poorly
+// like a photo. Transparent images get a transparent corner pixel.
+func noisyPNG(t *testing.T, w, h int, transparent bool) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	if transparent {
+		img.Set(0, 0, color.NRGBA{})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestShrinkImageDimension(t *testing.T) {
+	data := noisyPNG(t, 400, 200, false)
+	out, info, ok := shrinkImage(data, "photo.png", 100, 0)
+	if !ok {
+		t.Fatal("expected image to be resized")
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || cfg.Width != 100 || cfg.Height != 50 {
+		t.Errorf("got %s %dx%d, want jpeg 100x50", format, cfg.Width, cfg.Height)
+	}
+	if info.NewName != "photo.jpg" || info.OriginalWidth != 400 || info.Width != 100 || info.OriginalBytes != len(data) || info.Bytes != len(out) {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestShrinkImageBytes(t *testing.T) {
+	data := noisyPNG(t, 600, 600, true)
+	out, info, ok := shrinkImage(data, "shot.png", 0, 250*1024)
+	if !ok {
+		t.Fatal("expected image to be re-encoded")
+	}
+	if len(out) > 250*1024 {
+		t.Errorf("result is %d bytes, over the budget", len(out))
+	}
+	// Transparent images stay PNG.
+	if _, format, _ := image.DecodeConfig(bytes.NewReader(out)); format != "png" || info.NewName != "shot.png" {
+		t.Errorf("format = %s, name = %s, want png", format, info.NewName)
+	}
+	if info.Width >= 600 {
+		t.Errorf("width = %d, expected downscaling to meet the byte budget", info.Width)
+	}
+}
+
+func TestShrinkImageUnchanged(t *testing.T) {
+	data := noisyPNG(t, 50, 50, false)
+	if _, _, ok := shrinkImage(data, "small.png", 100, len(data)); ok {
+		t.Error("image within limits should not be changed")
+	}
+	if _, _, ok := shrinkImage([]byte("not an image"), "x.png", 1, 1); ok {
+		t.Error("undecodable data should not be changed")
+	}
+}
+
+func TestShrinkImageWebP(t *testing.T) {
+	data, _ := base64.StdEncoding.DecodeString(tinyWebP)
+	out, info, ok := shrinkImage(data, "pic.webp", 0, 1)
+	if !ok {
+		t.Fatal("expected WebP image to be decoded and re-encoded")
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(out)); err != nil || info.Width != 1 {
+		t.Errorf("re-encoded WebP: %v, info %+v", err, info)
+	}
+}
+
+func TestResizeImages(t *testing.T) {
+	dir := t.TempDir()
+	big := filepath.Join(dir, "big.png")
+	if err := os.WriteFile(big, noisyPNG(t, 300, 300, false), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(text, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	atts := []attachment{
+		{Source: big, Name: "big.png", Path: big},
+		{Source: text, Name: "notes.txt", Path: text},
+		{Source: "https://example.com/x.png", Name: "x.png", Path: "https://example.com/x.png"},
+	}
+
+	if out, resized, err := resizeImages(atts, uploadOptions{}); err != nil || len(resized) != 0 || out[0].Data != nil {
+		t.Error("images resized without limits")
+	}
+
+	out, resized, err := resizeImages(atts, uploadOptions{MaxImageDimension: 64})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resized) != 1 || resized[0].Name != "big.png" {
+		t.Fatalf("resized = %+v", resized)
+	}
+	if out[0].Name != "big.jpg" || out[0].Data == nil || out[0].Source != big {
+		t.Errorf("resized attachment = %+v", out[0])
+	}
+	if out[1].Path != text || out[2].Path != "https://example.com/x.png" {
+		t.Error("non-image attachments changed")
+	}
+}
+
+// pngHeaderOnly returns the signature and IHDR chunk of a w x h PNG, enough
+// for image.DecodeConfig but not for decoding.
+func pngHeaderOnly(w, h int) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9] = 8, 2 // 8-bit RGB
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(ihdr)))
+	chunk = append(chunk, "IHDR"...)
+	chunk = append(chunk, ihdr...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return append(append([]byte(nil), pngSignature...), chunk...)
+}
+
+func TestResizeImagesPixelBudget(t *testing.T) {
+	bomb := pngHeaderOnly(50000, 50000)
+	atts := []attachment{{Source: "bomb.png", Name: "bomb.png", Data: bomb}}
+	_, _, err := resizeImages(atts, uploadOptions{MaxImageDimension: 1024})
+	if err == nil || !strings.Contains(err.Error(), "megapixel limit") {
+		t.Errorf("expected pixel limit error, got %v", err)
+	}
+	if _, _, ok := shrinkImage(bomb, "bomb.png", 1024, 0); ok {
+		t.Error("shrinkImage decoded an image over the pixel budget")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// attachMode selects whether text files are embedded in the prompt or
+// uploaded, and whether URLs are fetched locally as web pages.
+type attachMode string
+
+const (
+	attachUpload attachMode = "upload" // upload every file (default)
+	attachInline attachMode = "inline" // embed every text file in the prompt
+	attachAuto   attachMode = "auto"   // embed text files up to the inline limit
+	attachWeb    attachMode = "web"    // fetch URLs locally as readable markdown, upload everything
+)
+
+const (
+	// defaultInlineLimit is the largest text file embedded in auto mode.
+	defaultInlineLimit = 16 * 1024
+	// maxInlineLimit is the largest text file embedded in any mode, whatever
+	// the inline limit asked for.
+	maxInlineLimit = 1024 * 1024
+)
+
+// parseAttachMode validates an attachment mode; empty means upload.
+func parseAttachMode(s string) (attachMode, error) {
+	switch m := attachMode(strings.ToLower(s)); m {
+	case "":
+		return attachUpload, nil
+	case attachUpload, attachInline, attachAuto, attachWeb:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid attachment mode %q (want inline, upload, auto or web)", s)
+	}
+}
+
+// inlineTextFiles splits attachments into text embedded in the prompt and the
+// remainder that still needs uploading. Local or in-memory UTF-8 text files
+// no larger than opts.InlineLimit are embedded; the limit defaults to
+// defaultInlineLimit in auto mode and maxInlineLimit in inline mode, and is
+// never more than maxInlineLimit. Larger files, URLs and binary files are
+// uploaded.
+func inlineTextFiles(atts []attachment, opts uploadOptions) (string, []attachment) {
+	if opts.Mode != attachInline && opts.Mode != attachAuto {
+		return "", atts
+	}
+	limit := int64(opts.InlineLimit)
+	if limit <= 0 {
+		limit = defaultInlineLimit
+		if opts.Mode == attachInline {
+			limit = maxInlineLimit
+		}
+	}
+	limit = min(limit, maxInlineLimit)
+
+	var sb strings.Builder
+	var rest []attachment
+	for _, a := range atts {
+		text, ok := attachmentText(a, limit)
+		if !ok {
+			rest = append(rest, a)
+			continue
+		}
+		label := a.Source
+		if a.Data != nil {
+			label = a.Name
+		}
+		sb.WriteString("\n\n")
+		sb.WriteString(fencedBlock(label, text))
+	}
+	return sb.String(), rest
+}
+
+// attachmentText returns the content of a if it is UTF-8 text within limit
+// bytes.
+func attachmentText(a attachment, limit int64) (string, bool) {
+	data := a.Data
+	if data == nil {
+		if a.Path == "" || isURL(a.Path) {
+			return "", false
+		}
+		info, err := os.Stat(a.Path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > limit {
+			return "", false
+		}
+		if data, err = os.ReadFile(a.Path); err != nil {
+			return "", false
+		}
+	}
+	if int64(len(data)) > limit {
+		return "", false
+	}
+	if !utf8.Valid(data) || strings.ContainsRune(string(data), 0) {
+		return "", false
+	}
+	return string(data), true
+}
+
+// fencedBlock formats text as a fenced code block labelled with its path.
+func fencedBlock(label, text string) string {
+	lang := strings.TrimPrefix(filepath.Ext(label), ".")
+	return fmt.Sprintf("File: %s\n%s", filepath.ToSlash(label), codeFence(lang, text))
+}
+
+// codeFence wraps text in a fenced code block. The fence is made longer than
+// any backtick run inside the text.
+func codeFence(lang, text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s%s\n%s", fence, lang, text)
+	if !strings.HasSuffix(text, "\n") {
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fence)
+	return sb.String()
+}
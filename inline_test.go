@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAttachMode(t *testing.T) {
+	for in, want := range map[string]attachMode{"": attachUpload, "upload": attachUpload, "INLINE": attachInline, "auto": attachAuto} {
+		got, err := parseAttachMode(in)
+		if err != nil || got != want {
+			t.Errorf("parseAttachMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseAttachMode("embed"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestFencedBlock(t *testing.T) {
+	got := fencedBlock("src/main.go", "package main")
+	want := "File: src/main.go\n```go\npackage main\n```"
+	if got != want {
+		t.Errorf("fencedBlock = %q, want %q", got, want)
+	}
+
+	// A fence inside the content must not terminate the block early.
+	got = fencedBlock("README.md", "```sh\nls\n```\n")
+	if !strings.HasPrefix(got, "File: README.md\n````md\n") || !strings.HasSuffix(got, "\n````") {
+		t.Errorf("fence not lengthened: %q", got)
+	}
+}
+
+func TestInlineTextFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"small.txt": "hello",
+		"large.txt": strings.Repeat("x", 100),
+		"image.png": string(pngHeader) + "\x00\x00",
+	})
+	atts := []attachment{
+		{Source: filepath.Join(root, "small.txt"), Name: "small.txt", Path: filepath.Join(root, "small.txt")},
+		{Source: filepath.Join(root, "large.txt"), Name: "large.txt", Path: filepath.Join(root, "large.txt")},
+		{Source: filepath.Join(root, "image.png"), Name: "image.png", Path: filepath.Join(root, "image.png")},
+		{Source: "https://example.com/a.txt", Name: "a.txt", Path: "https://example.com/a.txt"},
+	}
+
+	inline, rest := inlineTextFiles(atts, uploadOptions{Mode: attachUpload})
+	if inline != "" || len(rest) != 4 {
+		t.Errorf("upload mode inlined %q, kept %d", inline, len(rest))
+	}
+
+	inline, rest = inlineTextFiles(atts, uploadOptions{Mode: attachAuto, InlineLimit: 50})
+	if !strings.Contains(inline, "small.txt\n```txt\nhello\n```") {
+		t.Errorf("auto mode did not inline small.txt: %q", inline)
+	}
+	if strings.Contains(inline, "large.txt") || len(rest) != 3 {
+		t.Errorf("auto mode inlined over the limit: %q, kept %d", inline, len(rest))
+	}
+
+	inline, rest = inlineTextFiles(atts, uploadOptions{Mode: attachInline})
+	if !strings.Contains(inline, "large.txt") || len(rest) != 2 {
+		t.Errorf("inline mode: %q, kept %d", inline, len(rest))
+	}
+	for _, a := range rest {
+		if a.Name != "image.png" && a.Name != "a.txt" {
+			t.Errorf("unexpected upload %q", a.Name)
+		}
+	}
+
+	// An explicit limit applies in inline mode too.
+	inline, rest = inlineTextFiles(atts, uploadOptions{Mode: attachInline, InlineLimit: 50})
+	if strings.Contains(inline, "large.txt") || len(rest) != 3 {
+		t.Errorf("inline mode inlined over inline_limit: %q, kept %d", inline, len(rest))
+	}
+}
+
+func TestInlineTextFilesHardCap(t *testing.T) {
+	huge := attachment{Source: "huge.txt", Name: "huge.txt", Data: []byte(strings.Repeat("x", maxInlineLimit+1))}
+	for _, opts := range []uploadOptions{
+		{Mode: attachInline},
+		{Mode: attachInline, InlineLimit: 10 * maxInlineLimit},
+		{Mode: attachAuto, InlineLimit: 10 * maxInlineLimit},
+	} {
+		inline, rest := inlineTextFiles([]attachment{huge}, opts)
+		if inline != "" || len(rest) != 1 {
+			t.Errorf("%+v: inlined %d bytes over the hard cap", opts, len(inline))
+		}
+	}
+}
+
+func TestUploadFilesInlineOnly(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.go": "package a", "b.go": "package b"})
+
+	// Everything is inlined, so nothing touches the network.
+	res, err := uploadFiles(context.Background(), []string{root}, "fake-key", uploadOptions{Mode: attachAuto})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Attachments) != 0 {
+		t.Errorf("expected no uploads, got %d", len(res.Attachments))
+	}
+	inline := res.Inline
+	if !strings.Contains(inline, "a.go\n```go\npackage a") || !strings.Contains(inline, "b.go\n```go\npackage b") {
+		t.Errorf("directory files not inlined individually: %q", inline)
+	}
+}
@@ -11,6 +11,11 @@ import (
 var apiKey string
 
 func main() {
+	var err error
+	if cfg, err = loadConfig(os.Getenv("POE_MCP_CONFIG")); err != nil {
+		log.Fatal(err)
+	}
+
 	// If subcommand provided, run CLI mode.
 	if len(os.Args) > 1 {
 		if err := runCLI(os.Args[1:]); err != nil {
@@ -32,7 +37,10 @@ func main() {
 	)
 
 	registerQueryBot(server)
+	registerQueryLargeDocument(server)
 	registerSearchModels(server)
+	registerGetModel(server)
+	registerGetUsage(server)
 
 	if err := server.Run(context.Background(), &mcp.StdioTransport{}); err != nil {
 		log.Fatal(err)
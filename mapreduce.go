@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/n0madic/go-poe/client"
+	"github.com/n0madic/go-poe/models"
+	"github.com/n0madic/go-poe/types"
+)
+
+const (
+	// defaultContextTokens is assumed for bots without a catalog context length.
+	defaultContextTokens = 8192
+	// minChunkTokens keeps chunks useful even for tiny context windows.
+	minChunkTokens = 512
+	// promptOverheadTokens covers the instructions wrapped around each chunk.
+	promptOverheadTokens = 256
+	// maxConcurrentChunks bounds how many chunks are queried in parallel.
+	maxConcurrentChunks = 4
+	// maxDocumentBytes caps the documents query_large_document accepts.
+	maxDocumentBytes = 50 * 1024 * 1024
+)
+
+// askFunc sends a single prompt and returns the bot's answer.
+type askFunc func(ctx context.Context, prompt string) (string, error)
+
+// progressFunc reports map-reduce progress.
+type progressFunc func(done, total int, msg string)
+
+// mapReduceOptions configures a map-reduce run.
+type mapReduceOptions struct {
+	ChunkTokens   int // chunk size; derived from the context window if zero
+	OverlapTokens int // tokens repeated between neighbouring chunks
+	Concurrency   int
+}
+
+// withDefaults fills in the chunk size from the bot's catalog entry and an
+// overlap of 5% of the chunk.
+func (o mapReduceOptions) withDefaults(ctx context.Context, bot, question string) mapReduceOptions {
+	if o.ChunkTokens <= 0 {
+		all, _ := cache.get(ctx)
+		o.ChunkTokens = chunkBudget(all, bot, question)
+	}
+	if o.OverlapTokens <= 0 {
+		o.OverlapTokens = o.ChunkTokens / 20
+	}
+	return o
+}
+
+// mapReduceResult is the outcome of a map-reduce run.
+type mapReduceResult struct {
+	Answer      string
+	Partials    []string
+	Chunks      int
+	ChunkTokens int
+	Reductions  int // reduce queries, more than one when partials are reduced in groups
+}
+
+// chunkBudget derives a chunk size in tokens from the bot's context window,
+// leaving room for its output and the prompt around the chunk.
+func chunkBudget(all []models.Model, bot, question string) int {
+	contextTokens, maxOutput := defaultContextTokens, 0
+	if m := findModel(all, bot); m != nil {
+		if n, out := contextLimits(*m); n > 0 {
+			contextTokens, maxOutput = n, out
+		}
+	}
+	// Without a known output limit, keep a quarter of the window for the answer.
+	if maxOutput <= 0 || maxOutput > contextTokens/2 {
+		maxOutput = contextTokens / 4
+	}
+	budget := contextTokens - maxOutput - estimateTokens(question) - promptOverheadTokens
+	return max(budget, minChunkTokens)
+}
+
+// splitChunks splits text into chunks of at most size runes, with overlap
+// runes repeated at the start of each following chunk. Cuts prefer paragraph
+// breaks, then line breaks, then spaces in the last quarter of a chunk.
+func splitChunks(text string, size, overlap int) []string {
+	runes := []rune(text)
+	if size <= 0 || len(runes) <= size {
+		return []string{text}
+	}
+	overlap = min(max(overlap, 0), size/2)
+
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := start + size
+		if end >= len(runes) {
+			chunks = append(chunks, string(runes[start:]))
+			break
+		}
+		end = preferredCut(runes, start+size*3/4, end)
+		chunks = append(chunks, string(runes[start:end]))
+		next := end - overlap
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return chunks
+}
+
+// preferredCut returns the best cut position in runes[from:to].
+func preferredCut(runes []rune, from, to int) int {
+	window := string(runes[from:to])
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(window, sep); i >= 0 {
+			return from + utf8.RuneCountInString(window[:i+len(sep)])
+		}
+	}
+	return to
+}
+
+func mapPrompt(question string, i, n int, chunk string) string {
+	return fmt.Sprintf(`You are reading part %d of %d of a long document. Answer the question below using only this part. If this part contains nothing relevant, reply exactly "NO RELEVANT INFORMATION".
+
+Question: %s
+
+----- Part %d of %d -----
+%s
+----- End of part %d -----`, i+1, n, question, i+1, n, chunk, i+1)
+}
+
+func reducePrompt(question string, partials []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "A long document was split into parts and the question below was answered for each part separately. Combine the partial answers into one complete, consistent answer. Ignore parts with no relevant information and resolve duplicates.\n\nQuestion: %s\n", question)
+	for i, p := range partials {
+		fmt.Fprintf(&sb, "\n----- Partial answer %d -----\n%s\n", i+1, p)
+	}
+	return sb.String()
+}
+
+// mapReduce answers question over doc by querying every chunk concurrently
+// (map) and combining the partial answers (reduce). When the partial answers
+// together exceed the chunk budget they are reduced in groups, repeatedly,
+// until one answer remains.
+func mapReduce(ctx context.Context, doc, question string, ask askFunc, opts mapReduceOptions, progress progressFunc) (*mapReduceResult, error) {
+	if progress == nil {
+		progress = func(int, int, string) {}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = maxConcurrentChunks
+	}
+	chunkChars := opts.ChunkTokens * charsPerToken
+	chunks := splitChunks(doc, chunkChars, opts.OverlapTokens*charsPerToken)
+	res := &mapReduceResult{Chunks: len(chunks), ChunkTokens: opts.ChunkTokens}
+	if len(chunks) == 1 {
+		// Fits in one query: no reduce step needed.
+		progress(0, 1, "Querying the whole document")
+		answer, err := ask(ctx, mapPrompt(question, 0, 1, doc))
+		if err != nil {
+			return nil, err
+		}
+		progress(1, 1, "Done")
+		res.Answer = answer
+		res.Partials = []string{answer}
+		return res, nil
+	}
+
+	// One failed chunk fails the run, so it cancels the chunks still queued
+	// or in flight.
+	mapCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	total := len(chunks) + 1 // map steps plus at least one reduce
+	partials := make([]string, len(chunks))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		done     int
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-mapCtx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			if mapCtx.Err() != nil {
+				return
+			}
+			answer, err := ask(mapCtx, mapPrompt(question, i, len(chunks), chunk))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+					cancel()
+				}
+				return
+			}
+			partials[i] = answer
+			done++
+			progress(done, total, fmt.Sprintf("Mapped chunk %d of %d", done, len(chunks)))
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil {
+		// Chunks skipped because ctx itself was canceled leave no error.
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	res.Partials = partials
+
+	// Every reduce query is a progress step too. The number of rounds is only
+	// known as they happen, so the total grows by the groups of each round,
+	// counting one more step while another round is sure to follow. Progress
+	// is reported after each step, so it strictly increases.
+	current := partials
+	for len(current) > 1 || res.Reductions == 0 {
+		groups := groupPartials(current, chunkChars)
+		more := 0
+		if len(groups) > 1 {
+			more = 1
+		}
+		next := make([]string, len(groups))
+		for i, g := range groups {
+			answer, err := ask(ctx, reducePrompt(question, g))
+			if err != nil {
+				return nil, fmt.Errorf("reduce: %w", err)
+			}
+			next[i] = answer
+			res.Reductions++
+			done++
+			total = done + len(groups) - 1 - i + more
+			msg := fmt.Sprintf("Reduced %d partial answers", len(g))
+			if done == total {
+				msg = "Done"
+			}
+			progress(done, total, msg)
+		}
+		current = next
+	}
+	res.Answer = current[0]
+	return res, nil
+}
+
+// groupPartials packs partial answers into groups of at most limit runes each,
+// with at least two answers per group so every round makes progress.
+func groupPartials(partials []string, limit int) [][]string {
+	var groups [][]string
+	var cur []string
+	size := 0
+	for _, p := range partials {
+		n := utf8.RuneCountInString(p)
+		if len(cur) >= 2 && size+n > limit {
+			groups = append(groups, cur)
+			cur, size = nil, 0
+		}
+		cur = append(cur, p)
+		size += n
+	}
+	if len(cur) == 1 && len(groups) > 0 {
+		// Avoid a group of one, which would not shrink.
+		groups[len(groups)-1] = append(groups[len(groups)-1], cur[0])
+	} else if len(cur) > 0 {
+		groups = append(groups, cur)
+	}
+	return groups
+}
+
+// botAsker returns an askFunc that queries bot and strips any reasoning from
+// the answer. Every request sent is added to meter.
+func botAsker(bot, key string, temperature *float64, meter *usageMeter) askFunc {
+	return func(ctx context.Context, prompt string) (string, error) {
+		req := &types.QueryRequest{
+			BaseRequest: types.BaseRequest{
+				Version: types.ProtocolVersion,
+				Type:    types.RequestTypeQuery,
+			},
+			Query:       []types.ProtocolMessage{{Role: "user", Content: prompt}},
+			Temperature: temperature,
+		}
+		resp, err := client.GetFinalResponse(ctx, req, bot, key, nil)
+		meter.observe(req, resp)
+		if err != nil {
+			return "", err
+		}
+		if isEmptyResponse(resp) {
+			return "", fmt.Errorf("bot %q returned an empty response", bot)
+		}
+		_, text := splitThinking(resp.Text)
+		return text, nil
+	}
+}
+
+// loadDocument reads a text document from a local path, URL, data: URI or
+// base64 object.
+func loadDocument(ctx context.Context, f FileInput, sb *sandbox) (string, error) {
+	entry := f.entry()
+	var data []byte
+	switch {
+	case isDataURI(entry):
+		a, err := decodeDataURI(entry)
+		if err != nil {
+			return "", err
+		}
+		data = a.Data
+	case isURL(entry):
+		fetched, err := cfg.fetch(ctx, entry)
+		if err != nil {
+			return "", err
+		}
+		data = fetched.Data
+	default:
+		path, err := sb.resolve(entry)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("file %q: %w", entry, err)
+		}
+		if info.Size() > maxDocumentBytes {
+			return "", fmt.Errorf("file %q exceeds %d bytes", entry, maxDocumentBytes)
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return "", fmt.Errorf("file %q: %w", entry, err)
+		}
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("document %q is not UTF-8 text", entry)
+	}
+	return string(data), nil
+}
+
+// QueryLargeDocumentArgs defines the input schema for the query_large_document tool.
+type QueryLargeDocumentArgs struct {
+	Bot           string    `json:"bot" jsonschema:"Bot name on Poe.com"`
+	Question      string    `json:"question" jsonschema:"Question or instruction to apply to the whole document"`
+	File          FileInput `json:"file,omitempty" jsonschema:"Text document: local path, URL, data: URI or {name, mime_type, base64} object"`
+	Text          string    `json:"text,omitempty" jsonschema:"Document text, instead of file"`
+	ChunkTokens   int       `json:"chunk_tokens,omitempty" jsonschema:"Chunk size in tokens (default: derived from the bot's context window)"`
+	OverlapTokens int       `json:"overlap_tokens,omitempty" jsonschema:"Tokens repeated between neighbouring chunks (default: 5% of the chunk size)"`
+	Temperature   *float64  `json:"temperature,omitempty" jsonschema:"Sampling temperature (0.0-2.0)"`
+}
+
+// QueryLargeDocumentResult defines the structured output of the query_large_document tool.
+type QueryLargeDocumentResult struct {
+	Bot          string      `json:"bot"`
+	Text         string      `json:"text" jsonschema:"Combined answer"`
+	Chunks       int         `json:"chunks" jsonschema:"Number of chunks the document was split into"`
+	ChunkTokens  int         `json:"chunk_tokens" jsonschema:"Chunk size in tokens"`
+	Reductions   int         `json:"reductions" jsonschema:"Number of reduce queries"`
+	Partials     []string    `json:"partials,omitempty" jsonschema:"Answer for each chunk"`
+	FinishStatus string      `json:"finish_status" jsonschema:"complete or error"`
+	Error        string      `json:"error,omitempty"`
+	LatencyMs    int64       `json:"latency_ms"`
+	Usage        *QueryUsage `json:"usage,omitempty" jsonschema:"Token usage and estimated cost of all map and reduce queries"`
+}
+
+func registerQueryLargeDocument(server *mcp.Server) {
+	inputSchema, err := inputSchemaFor[QueryLargeDocumentArgs]()
+	if err != nil {
+		panic(fmt.Sprintf("query_large_document input schema: %v", err))
+	}
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "query_large_document",
+		Description: "Answer a question about a text document larger than the bot's context window. The document is split into overlapping chunks sized from the bot's context length, each chunk is queried concurrently, and the partial answers are combined. Progress is reported while chunks are processed.",
+		InputSchema: inputSchema,
+	}, handleQueryLargeDocument)
+}
+
+func handleQueryLargeDocument(ctx context.Context, req *mcp.CallToolRequest, args QueryLargeDocumentArgs) (*mcp.CallToolResult, *QueryLargeDocumentResult, error) {
+	result := &QueryLargeDocumentResult{Bot: args.Bot}
+	fail := func(msg string) (*mcp.CallToolResult, *QueryLargeDocumentResult, error) {
+		result.FinishStatus = finishError
+		result.Error = msg
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: msg}}, IsError: true}, result, nil
+	}
+	if apiKey == "" {
+		return fail("POE_API_KEY environment variable is required")
+	}
+
+	if len(args.Text) > maxDocumentBytes {
+		return fail(fmt.Sprintf("text exceeds %d bytes", maxDocumentBytes))
+	}
+	doc := args.Text
+	if doc == "" {
+		if args.File.entry() == "" {
+			return fail("either file or text is required")
+		}
+		var err error
+		if doc, err = loadDocument(ctx, args.File, sandboxForRequest(ctx, req)); err != nil {
+			return fail(fmt.Sprintf("Error reading document: %v", err))
+		}
+	}
+
+	opts := mapReduceOptions{ChunkTokens: args.ChunkTokens, OverlapTokens: args.OverlapTokens}.withDefaults(ctx, args.Bot, args.Question)
+
+	meter := &usageMeter{}
+	start := time.Now()
+	res, err := mapReduce(ctx, doc, args.Question, botAsker(args.Bot, apiKey, args.Temperature, meter), opts, mcpProgress(ctx, req))
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if result.Usage = meter.usage(ctx, args.Bot); result.Usage != nil {
+		sessionUsage.record(args.Bot, result.Usage)
+	}
+	if err != nil {
+		return fail(fmt.Sprintf("Error querying bot %q: %v", args.Bot, err))
+	}
+	result.Text = res.Answer
+	result.Chunks = res.Chunks
+	result.ChunkTokens = res.ChunkTokens
+	result.Reductions = res.Reductions
+	result.Partials = res.Partials
+	result.FinishStatus = finishComplete
+
+	note := fmt.Sprintf("Processed %d chunk(s) of about %d tokens with %d reduce step(s).", res.Chunks, res.ChunkTokens, res.Reductions)
+	if result.Usage != nil {
+		note += "\nUsage: " + result.Usage.String()
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: res.Answer},
+			&mcp.TextContent{Text: note},
+		},
+	}, result, nil
+}
+
+// mcpProgress sends progress notifications when the client asked for them.
+func mcpProgress(ctx context.Context, req *mcp.CallToolRequest) progressFunc {
+	if req == nil || req.Session == nil || req.Params == nil || req.Params.GetProgressToken() == nil {
+		return nil
+	}
+	token := req.Params.GetProgressToken()
+	return func(done, total int, msg string) {
+		req.Session.NotifyProgress(ctx, &mcp.ProgressNotificationParams{
+			ProgressToken: token,
+			Progress:      float64(done),
+			Total:         float64(total),
+			Message:       msg,
+		})
+	}
+}
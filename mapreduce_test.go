@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/n0madic/go-poe/models"
+)
+
+func TestSplitChunks(t *testing.T) {
+	var paras []string
+	for i := range 20 {
+		paras = append(paras, fmt.Sprintf("Paragraph %02d %s", i, strings.Repeat("word ", 8)))
+	}
+	text := strings.Join(paras, "\n\n")
+
+	chunks := splitChunks(text, 300, 40)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+	for i, c := range chunks {
+		if n := utf8.RuneCountInString(c); n > 300 {
+			t.Errorf("chunk %d has %d runes, want <= 300", i, n)
+		}
+		if i < len(chunks)-1 && !strings.HasSuffix(c, "\n\n") {
+			t.Errorf("chunk %d does not end at a paragraph break: %q", i, c[len(c)-10:])
+		}
+	}
+	// Every paragraph appears somewhere, and neighbours overlap.
+	joined := strings.Join(chunks, "")
+	for _, p := range paras {
+		if !strings.Contains(joined, p) {
+			t.Errorf("paragraph %q missing from chunks", p[:12])
+		}
+	}
+	for i := 1; i < len(chunks); i++ {
+		prev := chunks[i-1]
+		if !strings.HasPrefix(chunks[i], prev[len(prev)-40:]) {
+			t.Errorf("chunk %d does not start with the end of chunk %d", i, i-1)
+		}
+	}
+}
+
+func TestSplitChunks_Small(t *testing.T) {
+	if got := splitChunks("short text", 100, 10); len(got) != 1 || got[0] != "short text" {
+		t.Errorf("splitChunks() = %q, want the text unchanged", got)
+	}
+}
+
+func TestSplitChunks_Runes(t *testing.T) {
+	text := strings.Repeat("ж", 1000) // no separators, multi-byte runes
+	chunks := splitChunks(text, 300, 30)
+	total := 0
+	for i, c := range chunks {
+		if !utf8.ValidString(c) {
+			t.Fatalf("chunk %d is not valid UTF-8", i)
+		}
+		total += utf8.RuneCountInString(c)
+	}
+	if want := 1000 + 30*(len(chunks)-1); total != want {
+		t.Errorf("chunks hold %d runes, want %d", total, want)
+	}
+}
+
+func TestChunkBudget(t *testing.T) {
+	maxOut := 4096
+	all := []models.Model{
+		{ID: "Big", ContextWindow: &models.ContextWindow{ContextLength: 128000, MaxOutputTokens: &maxOut}},
+		{ID: "NoOutput", ContextWindow: &models.ContextWindow{ContextLength: 32000}},
+	}
+	tests := []struct {
+		bot  string
+		want int
+	}{
+		{"Big", 128000 - 4096 - promptOverheadTokens},
+		{"NoOutput", 32000 - 8000 - promptOverheadTokens},
+		{"Unknown", defaultContextTokens - defaultContextTokens/4 - promptOverheadTokens},
+	}
+	for _, tt := range tests {
+		if got := chunkBudget(all, tt.bot, ""); got != tt.want {
+			t.Errorf("chunkBudget(%s) = %d, want %d", tt.bot, got, tt.want)
+		}
+	}
+	tiny := []models.Model{{ID: "Tiny", ContextWindow: &models.ContextWindow{ContextLength: 600}}}
+	if got := chunkBudget(tiny, "Tiny", ""); got != minChunkTokens {
+		t.Errorf("chunkBudget(Tiny) = %d, want %d", got, minChunkTokens)
+	}
+}
+
+func TestMapReduce(t *testing.T) {
+	doc := strings.Repeat("lorem ipsum dolor sit amet ", 200)
+	var (
+		mu      sync.Mutex
+		maps    int
+		reduces []string
+		running atomic.Int32
+		peak    atomic.Int32
+	)
+	ask := func(ctx context.Context, prompt string) (string, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > peak.Load() {
+			peak.Store(n)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(prompt, "A long document") {
+			reduces = append(reduces, prompt)
+			return "final answer", nil
+		}
+		maps++
+		return fmt.Sprintf("partial %d", maps), nil
+	}
+	var events []string
+	progress := func(done, total int, msg string) {
+		events = append(events, fmt.Sprintf("%d/%d", done, total))
+	}
+
+	res, err := mapReduce(context.Background(), doc, "What is it?", ask, mapReduceOptions{ChunkTokens: 200, OverlapTokens: 10, Concurrency: 2}, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Answer != "final answer" {
+		t.Errorf("Answer = %q", res.Answer)
+	}
+	if res.Chunks < 2 || maps != res.Chunks || len(res.Partials) != res.Chunks {
+		t.Errorf("Chunks = %d, map queries = %d, partials = %d", res.Chunks, maps, len(res.Partials))
+	}
+	if res.Reductions != 1 || len(reduces) != 1 {
+		t.Fatalf("Reductions = %d, want 1", res.Reductions)
+	}
+	for _, p := range res.Partials {
+		if !strings.Contains(reduces[0], p) {
+			t.Errorf("reduce prompt is missing %q", p)
+		}
+	}
+	if peak.Load() > 2 {
+		t.Errorf("peak concurrency = %d, want <= 2", peak.Load())
+	}
+	if last := events[len(events)-1]; last != fmt.Sprintf("%d/%d", res.Chunks+1, res.Chunks+1) {
+		t.Errorf("last progress = %s", last)
+	}
+}
+
+func TestMapReduce_Hierarchical(t *testing.T) {
+	doc := strings.Repeat("x", 8000)
+	ask := func(ctx context.Context, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "A long document") {
+			return "combined", nil
+		}
+		return strings.Repeat("p", 300), nil // partials too long to reduce at once
+	}
+	res, err := mapReduce(context.Background(), doc, "q", ask, mapReduceOptions{ChunkTokens: 200}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reductions < 2 {
+		t.Errorf("Reductions = %d, want several rounds", res.Reductions)
+	}
+	if res.Answer != "combined" {
+		t.Errorf("Answer = %q", res.Answer)
+	}
+}
+
+func TestMapReduce_SingleChunk(t *testing.T) {
+	calls := 0
+	ask := func(ctx context.Context, prompt string) (string, error) {
+		calls++
+		return "answer", nil
+	}
+	res, err := mapReduce(context.Background(), "small", "q", ask, mapReduceOptions{ChunkTokens: 100}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || res.Reductions != 0 || res.Answer != "answer" {
+		t.Errorf("calls = %d, reductions = %d, answer = %q", calls, res.Reductions, res.Answer)
+	}
+}
+
+func TestMapReduce_ChunkError(t *testing.T) {
+	ask := func(ctx context.Context, prompt string) (string, error) {
+		if strings.Contains(prompt, "part 2 of") {
+			return "", errors.New("boom")
+		}
+		return "ok", nil
+	}
+	_, err := mapReduce(context.Background(), strings.Repeat("y", 4000), "q", ask, mapReduceOptions{ChunkTokens: 200}, nil)
+	if err == nil || !strings.Contains(err.Error(), "chunk 2 of") || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("err = %v, want chunk 2 failure", err)
+	}
+}
+
+func TestMapReduce_ProgressIncreases(t *testing.T) {
+	ask := func(ctx context.Context, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "A long document") {
+			return "combined", nil
+		}
+		return strings.Repeat("p", 300), nil
+	}
+	type event struct{ done, total int }
+	var events []event
+	progress := func(done, total int, msg string) {
+		events = append(events, event{done, total})
+	}
+	res, err := mapReduce(context.Background(), strings.Repeat("x", 8000), "q", ask, mapReduceOptions{ChunkTokens: 200, Concurrency: 1}, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != res.Chunks+res.Reductions {
+		t.Errorf("%d progress events, want one per query (%d)", len(events), res.Chunks+res.Reductions)
+	}
+	for i, e := range events {
+		if e.done > e.total {
+			t.Errorf("event %d: progress %d exceeds total %d", i, e.done, e.total)
+		}
+		if i > 0 && e.done <= events[i-1].done {
+			t.Errorf("event %d: progress %d does not increase from %d", i, e.done, events[i-1].done)
+		}
+	}
+	if last := events[len(events)-1]; last.done != last.total {
+		t.Errorf("last progress = %d/%d, want complete", last.done, last.total)
+	}
+}
+
+func TestMapReduce_ChunkErrorCancelsSiblings(t *testing.T) {
+	var uncanceled atomic.Int32
+	ask := func(ctx context.Context, prompt string) (string, error) {
+		if strings.Contains(prompt, "part 1 of") {
+			return "", errors.New("boom")
+		}
+		// Siblings run until the failure cancels them.
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(5 * time.Second):
+			uncanceled.Add(1)
+			return "ok", nil
+		}
+	}
+	_, err := mapReduce(context.Background(), strings.Repeat("y", 8000), "q", ask, mapReduceOptions{ChunkTokens: 200, Concurrency: 100}, nil)
+	if err == nil || !strings.Contains(err.Error(), "chunk 1 of") || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("err = %v, want chunk 1 failure", err)
+	}
+	if n := uncanceled.Load(); n > 0 {
+		t.Errorf("%d sibling chunks were not canceled", n)
+	}
+}
+
+func TestQueryLargeDocumentTextLimit(t *testing.T) {
+	origKey := apiKey
+	defer func() { apiKey = origKey }()
+	apiKey = "test-key"
+
+	args := QueryLargeDocumentArgs{Bot: "Claude", Question: "q", Text: strings.Repeat("x", maxDocumentBytes+1)}
+	res, out, err := handleQueryLargeDocument(context.Background(), nil, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IsError || !strings.Contains(out.Error, "exceeds") {
+		t.Errorf("error = %q, want a size limit failure", out.Error)
+	}
+}
+
+func TestLoadDocument(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "doc.txt")
+	if err := os.WriteFile(path, []byte("hello document"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "doc.bin")
+	if err := os.WriteFile(bin, []byte{0xff, 0xfe, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := loadDocument(context.Background(), FileInput{Path: path}, nil); err != nil || got != "hello document" {
+		t.Errorf("loadDocument(path) = %q, %v", got, err)
+	}
+	if got, err := loadDocument(context.Background(), FileInput{Name: "a.txt", Base64: "aGk="}, nil); err != nil || got != "hi" {
+		t.Errorf("loadDocument(base64) = %q, %v", got, err)
+	}
+	if _, err := loadDocument(context.Background(), FileInput{Path: bin}, nil); err == nil {
+		t.Error("loadDocument(binary) succeeded, want an error")
+	}
+}
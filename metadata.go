@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errUnsupportedImage means stripMetadata does not handle the format.
+var errUnsupportedImage = errors.New("unsupported image format")
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	iccProfileID = []byte("ICC_PROFILE\x00")
+)
+
+// metadataFreeImages are image types that hold no camera metadata: formats
+// with no place for it, and vector images.
+var metadataFreeImages = map[string]bool{
+	"image/bmp": true, "image/x-icon": true, "image/vnd.microsoft.icon": true,
+	"image/svg+xml": true,
+}
+
+// stripImageMetadata removes EXIF, XMP and IPTC metadata from JPEG, PNG, WebP
+// and GIF images, so location and device details are not uploaded. Pixel
+// data is copied unchanged and the EXIF orientation is kept, so photos still
+// display upright. Images without metadata and other files are left as they
+// are.
+//
+// A URL's name says little about what it serves, so every URL is downloaded
+// under the URL policy and judged by its content and Content-Type. The
+// download is uploaded in place of the URL, so Poe never fetches an original
+// that still holds metadata.
+//
+// Stripping fails closed: an image that cannot be read or stripped, or whose
+// format is not supported (such as HEIC or TIFF), is an error rather than
+// being uploaded with its metadata. Missing files are left for the upload to
+// report, since nothing is sent for them.
+func stripImageMetadata(ctx context.Context, atts []attachment) ([]attachment, error) {
+	out := make([]attachment, len(atts))
+	for i, a := range atts {
+		out[i] = a
+		if a.Data == nil && a.Path == "" {
+			continue
+		}
+		var served string // media type the URL was served as
+		if a.Data == nil && isURL(a.Path) {
+			fetched, err := cfg.fetch(ctx, a.Path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot download %s to strip image metadata: %w (set keep_metadata to let Poe fetch it)", a.Source, err)
+			}
+			served, _, _ = mime.ParseMediaType(fetched.ContentType)
+			a = attachment{Source: a.Source, Name: a.Name, Data: fetched.Data}
+			out[i] = a
+		}
+		mimeType, err := sniffMIME(a)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, metadataError(a, err)
+		}
+		if !strings.HasPrefix(mimeType, "image/") && strings.HasPrefix(served, "image/") {
+			mimeType = served
+		}
+		if !strings.HasPrefix(mimeType, "image/") || metadataFreeImages[mimeType] {
+			continue
+		}
+		if a.Data != nil && filepath.Ext(a.Name) == "" {
+			// Name a downloaded image so Poe can tell its type.
+			a.Name += extensionForMIME(mimeType)
+			out[i] = a
+		}
+		data := a.Data
+		if data == nil {
+			data, err = os.ReadFile(a.Path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, metadataError(a, err)
+			}
+		}
+		stripped, err := stripMetadata(data)
+		if err != nil {
+			return nil, metadataError(a, err)
+		}
+		if len(stripped) != len(data) {
+			out[i] = attachment{Source: a.Source, Name: a.Name, Data: stripped}
+		}
+	}
+	return out, nil
+}
+
+func metadataError(a attachment, err error) error {
+	return fmt.Errorf("cannot strip metadata from image %s: %w (set keep_metadata to upload it unchanged)", a.Source, err)
+}
+
+// stripMetadata returns data without metadata, detecting the format from its
+// signature. Any EXIF orientation is kept.
+func stripMetadata(data []byte) ([]byte, error) {
+	orientation := exifOrientation(data)
+	switch {
+	case len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8:
+		return stripJPEG(data, orientation)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNG(data, orientation)
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebP(data, orientation)
+	case bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a")):
+		return stripGIF(data)
+	default:
+		return nil, errUnsupportedImage
+	}
+}
+
+// stripJPEG drops APP1 (EXIF, XMP), APP13 (IPTC) and the other application
+// and comment segments before the image data. JFIF (APP0), ICC profiles
+// (APP2) and the Adobe colour transform (APP14) are kept because decoders
+// need them to render colours correctly. An orientation other than 1 is
+// written back as an EXIF segment holding nothing else.
+func stripJPEG(data []byte, orientation int) ([]byte, error) {
+	out := []byte{0xFF, 0xD8}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan: the rest is entropy-coded data.
+			return append(out, data[i:]...), nil
+		}
+		if marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		if keepJPEGSegment(marker, data[i+4:end]) {
+			out = append(out, data[i:end]...)
+		} else if orientation > 1 && marker == 0xE1 && bytes.HasPrefix(data[i+4:end], exifHeader) {
+			payload := append(append([]byte(nil), exifHeader...), orientationTIFF(orientation)...)
+			out = append(out, 0xFF, 0xE1)
+			out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+			out = append(out, payload...)
+			orientation = 1 // once
+		}
+		i = end
+	}
+	return nil, errors.New("JPEG has no image data")
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0, marker == 0xEE:
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, iccProfileID)
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	default:
+		return true
+	}
+}
+
+// pngMetadataChunks are dropped from PNG files. XMP is stored in an iTXt chunk.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true,
+}
+
+// stripPNG drops metadata chunks. Chunks are copied whole, so their CRCs stay
+// valid. An orientation other than 1 is written back as an eXIf chunk holding
+// nothing else.
+func stripPNG(data []byte, orientation int) ([]byte, error) {
+	out := append([]byte(nil), pngSignature...)
+	i := len(pngSignature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		typ := string(data[i+4 : i+8])
+		if !pngMetadataChunks[typ] {
+			out = append(out, data[i:end]...)
+		} else if typ == "eXIf" && orientation > 1 {
+			tiff := orientationTIFF(orientation)
+			chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+			chunk = append(chunk, "eXIf"...)
+			chunk = append(chunk, tiff...)
+			out = append(out, binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))...)
+		}
+		i = end
+		if typ == "IEND" {
+			return out, nil
+		}
+	}
+	return nil, errors.New("PNG has no IEND chunk")
+}
+
+// VP8X feature flags for metadata chunks.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP drops EXIF and XMP chunks from an extended WebP file, clears the
+// matching VP8X flags and fixes the RIFF size. An orientation other than 1 is
+// written back as an EXIF chunk holding nothing else.
+func stripWebP(data []byte, orientation int) ([]byte, error) {
+	dropFlags := byte(webpFlagEXIF | webpFlagXMP)
+	if orientation > 1 {
+		dropFlags = webpFlagXMP
+	}
+	out := append([]byte(nil), data[:12]...)
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // chunks are padded to an even size
+		if end > len(data) {
+			if i+8+size != len(data) {
+				return nil, errors.New("truncated WebP chunk")
+			}
+			end = len(data)
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF":
+			if orientation > 1 {
+				tiff := orientationTIFF(orientation)
+				out = append(out, "EXIF"...)
+				out = binary.LittleEndian.AppendUint32(out, uint32(len(tiff)))
+				out = append(out, tiff...)
+				if len(tiff)%2 == 1 {
+					out = append(out, 0)
+				}
+			}
+		case "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= dropFlags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// gifKeptApplications are the application extensions stripGIF keeps: looping
+// for animations and the ICC colour profile.
+var gifKeptApplications = map[string]bool{
+	"NETSCAPE2.0": true, "ANIMEXTS1.0": true, "ICCRGBG1012": true,
+}
+
+// stripGIF drops comment extensions and application extensions such as XMP,
+// keeping those needed to play and render the image.
+func stripGIF(data []byte) ([]byte, error) {
+	errTruncated := errors.New("truncated GIF")
+	const headerLen = 13 // signature and logical screen descriptor
+	if len(data) < headerLen {
+		return nil, errTruncated
+	}
+	i := headerLen
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&7 + 1) // global colour table
+	}
+	if i > len(data) {
+		return nil, errTruncated
+	}
+	out := append([]byte(nil), data[:i]...)
+	// skipSubBlocks returns the offset after the sub-blocks starting at j.
+	skipSubBlocks := func(j int) (int, error) {
+		for j < len(data) {
+			n := int(data[j])
+			j += 1 + n
+			if n == 0 {
+				return j, nil
+			}
+		}
+		return 0, errTruncated
+	}
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, errTruncated
+			}
+			label := data[i+1]
+			end, err := skipSubBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			keep := label != 0xFE // comment
+			if label == 0xFF {
+				id := data[i+2 : end]
+				keep = len(id) >= 12 && gifKeptApplications[string(id[1:12])]
+			}
+			if keep {
+				out = append(out, data[start:end]...)
+			}
+			i = end
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return nil, errTruncated
+			}
+			j := i + 10
+			if flags := data[i+9]; flags&0x80 != 0 {
+				j += 3 << (flags&7 + 1) // local colour table
+			}
+			end, err := skipSubBlocks(j + 1) // after the LZW minimum code size
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[start:end]...)
+			i = end
+		case 0x3B: // trailer
+			return append(out, 0x3B), nil
+		default:
+			return nil, errors.New("invalid GIF block")
+		}
+	}
+	return nil, errors.New("GIF has no trailer")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata carry EXIF with a GPS IFD, XMP with a GPS
+// latitude, and (for JPEG) an IPTC block and a comment.
+var metadataMarkers = []string{"Exif", "Cam", "xmpmeta", "GPSLatitude", "Photoshop", "8BIM"}
+
+func TestStripMetadataFixtures(t *testing.T) {
+	for _, name := range []string{"gps.jpg", "gps.png", "gps.webp"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(data, []byte("GPSLatitude")) {
+				t.Fatal("fixture has no metadata to strip")
+			}
+
+			out, err := stripMetadata(data)
+			if err != nil {
+				t.Fatalf("stripMetadata: %v", err)
+			}
+			for _, m := range metadataMarkers {
+				if bytes.Contains(out, []byte(m)) {
+					t.Errorf("output still contains %q", m)
+				}
+			}
+
+			// The image must still decode to the same size and pixels.
+			want, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := image.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("stripped image does not decode: %v", err)
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("bounds = %v, want %v", got.Bounds(), want.Bounds())
+			}
+			b := want.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if got.At(x, y) != want.At(x, y) {
+						t.Fatalf("pixel (%d,%d) changed", x, y)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStripMetadataWebPFlags(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "gps.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := stripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := out[20]; flags&(webpFlagEXIF|webpFlagXMP) != 0 {
+		t.Errorf("VP8X flags = %#x, metadata bits still set", flags)
+	}
+	if size := int(out[4]) | int(out[5])<<8 | int(out[6])<<16 | int(out[7])<<24; size != len(out)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(out)-8)
+	}
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "gps.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/doc" {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("plain text"))
+			return
+		}
+		w.Write(fixture)
+	}))
+	defer srv.Close()
+	// The test server listens on loopback, which the URL policy blocks by default.
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg.URLPolicy.AllowPrivate = true
+
+	ctx := context.Background()
+	path := filepath.Join("testdata", "gps.jpg")
+	atts := []attachment{
+		{Source: path, Name: "gps.jpg", Path: path},
+		{Source: srv.URL + "/a.jpg", Name: "a.jpg", Path: srv.URL + "/a.jpg"},
+		{Source: srv.URL + "/doc", Name: "doc", Path: srv.URL + "/doc"},
+		{Source: srv.URL + "/photo?id=1", Name: "photo", Path: srv.URL + "/photo?id=1"},
+	}
+	out, err := stripImageMetadata(ctx, atts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out[0].Data == nil || out[0].Name != "gps.jpg" || bytes.Contains(out[0].Data, []byte("Exif")) {
+		t.Errorf("local image not stripped: %+v", out[0].Name)
+	}
+	if out[1].Data == nil || out[1].Name != "a.jpg" || bytes.Contains(out[1].Data, []byte("GPSLatitude")) {
+		t.Errorf("URL image not downloaded and stripped: %+v", out[1].Name)
+	}
+	if string(out[2].Data) != "plain text" || out[2].Path != "" {
+		t.Errorf("non-image URL should be uploaded as downloaded, got %q", out[2].Data)
+	}
+	if out[3].Data == nil || out[3].Name != "photo.jpg" || bytes.Contains(out[3].Data, []byte("GPSLatitude")) {
+		t.Errorf("image URL without an extension not stripped: %+v", out[3].Name)
+	}
+
+	// Already clean images keep their path, so the upload cache still applies.
+	clean := filepath.Join(t.TempDir(), "clean.jpg")
+	if err := os.WriteFile(clean, out[0].Data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := stripImageMetadata(ctx, []attachment{{Source: clean, Name: "clean.jpg", Path: clean}})
+	if err != nil || again[0].Data != nil {
+		t.Errorf("clean image was rewritten (err %v)", err)
+	}
+
+	// Missing files are reported by the upload.
+	if _, err := stripImageMetadata(ctx, []attachment{{Source: "/no/such.jpg", Name: "such.jpg", Path: "/no/such.jpg"}}); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestStripImageMetadataFailsClosed(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "gps.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiff := append([]byte("II*\x00\x08\x00\x00\x00"), "GPSLatitude"...)
+	for name, data := range map[string][]byte{
+		"corrupt.jpg": fixture[:40],
+		"photo.tiff":  tiff,
+		"photo.heic":  append([]byte("\x00\x00\x00\x18ftypheic"), make([]byte, 16)...),
+	} {
+		atts := []attachment{{Source: name, Name: name, Data: data}}
+		if _, err := stripImageMetadata(context.Background(), atts); err == nil || !strings.Contains(err.Error(), "keep_metadata") {
+			t.Errorf("%s: expected an error, got %v", name, err)
+		}
+	}
+}
+
+func TestStripMetadataUnsupported(t *testing.T) {
+	if _, err := stripMetadata([]byte("II*\x00\x08\x00\x00\x00")); err != errUnsupportedImage {
+		t.Errorf("err = %v, want errUnsupportedImage", err)
+	}
+}
+
+func TestStripMetadataKeepsOrientation(t *testing.T) {
+	jpg := orientedJPEG(t, 8, 4, 6)
+	out, err := stripMetadata(jpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := exifOrientation(out); o != 6 {
+		t.Errorf("JPEG orientation = %d, want 6", o)
+	}
+
+	// A PNG with an eXIf chunk holding the orientation and a GPS marker.
+	img := noisyPNG(t, 4, 4, false)
+	tiff := append(orientationTIFF(8), "GPSLatitude"...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, tiff...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	ihdrEnd := len(pngSignature) + 25
+	png := append(append(append([]byte(nil), img[:ihdrEnd]...), chunk...), img[ihdrEnd:]...)
+	if o := exifOrientation(png); o != 8 {
+		t.Fatalf("fixture orientation = %d, want 8", o)
+	}
+	out, err = stripMetadata(png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := exifOrientation(out); o != 8 || bytes.Contains(out, []byte("GPSLatitude")) {
+		t.Errorf("PNG orientation = %d, GPS kept = %v; want 8 and no GPS", o, bytes.Contains(out, []byte("GPSLatitude")))
+	}
+	if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped PNG does not decode: %v", err)
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	var buf bytes.Buffer
+	pal := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, pal, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Insert a comment and an XMP application extension before the image.
+	comment := append([]byte{0x21, 0xFE, 5}, "hello"...)
+	comment = append(comment, 0)
+	xmp := append([]byte{0x21, 0xFF, 11}, "XMP DataXMP"...)
+	xmp = append(xmp, 11)
+	xmp = append(xmp, "GPSLatitude"...)
+	xmp = append(xmp, 0)
+	at := bytes.IndexByte(data[13+6:], 0x2C) + 13 + 6 // after the two-colour table
+	withMeta := append(append(append(append([]byte(nil), data[:at]...), comment...), xmp...), data[at:]...)
+
+	out, err := stripMetadata(withMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("hello")) || bytes.Contains(out, []byte("GPSLatitude")) {
+		t.Error("GIF metadata not stripped")
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("stripped GIF differs from the original:\n%x\n%x", out, data)
+	}
+}
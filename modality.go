@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/n0madic/go-poe/models"
+)
+
+// maxModalitySuggestions caps how many compatible models a mismatch error lists.
+const maxModalitySuggestions = 5
+
+// inputModalities returns the input modalities of m, preferring the explicit
+// list and falling back to the part of Architecture.Modality before "->".
+func inputModalities(m models.Model) []string {
+	if len(m.Architecture.InputModalities) > 0 {
+		return lowerAll(m.Architecture.InputModalities)
+	}
+	in, _ := parseModality(m.Architecture.Modality)
+	return in
+}
+
+// outputModalities returns the output modalities of m, preferring the
+// explicit list and falling back to the part of Architecture.Modality after
+// "->".
+func outputModalities(m models.Model) []string {
+	if len(m.Architecture.OutputModalities) > 0 {
+		return lowerAll(m.Architecture.OutputModalities)
+	}
+	_, out := parseModality(m.Architecture.Modality)
+	return out
+}
+
+// parseModality splits a catalog modality such as "text,image->text" into its
+// input and output sets. Without "->" every modality is an input.
+func parseModality(modality string) (in, out []string) {
+	inPart, outPart, _ := strings.Cut(modality, "->")
+	return splitModalities([]string{inPart}), splitModalities([]string{outPart})
+}
+
+// splitModalities flattens comma-separated lists of modalities, lowercased
+// and without duplicates.
+func splitModalities(lists []string) []string {
+	var out []string
+	for _, list := range lists {
+		for _, s := range strings.Split(list, ",") {
+			if s = strings.ToLower(strings.TrimSpace(s)); s != "" && !containsString(out, s) {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func lowerAll(in []string) []string {
+	out := make([]string, len(in))
+	for i, s := range in {
+		out[i] = strings.ToLower(strings.TrimSpace(s))
+	}
+	return out
+}
+
+// sniffMIME determines the MIME type of an attachment. Local files and
+// in-memory data are sniffed from their content, falling back to the
+// extension; URLs use the extension only. An empty string means the type is
+// unknown.
+func sniffMIME(a attachment) (string, error) {
+	if a.Data != nil {
+		return refineMIME(http.DetectContentType(a.Data), a.Name), nil
+	}
+	if isURL(a.Path) {
+		u, err := url.Parse(a.Path)
+		if err != nil {
+			return "", nil
+		}
+		return mimeFromExt(path.Ext(u.Path)), nil
+	}
+
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return "", fmt.Errorf("file %q: %w", a.Path, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("file %q: %w", a.Path, err)
+	}
+	return refineMIME(http.DetectContentType(buf[:n]), a.Path), nil
+}
+
+// refineMIME replaces the generic types http.DetectContentType falls back to
+// with the more specific type implied by the file extension (e.g. .pdf, .json).
+func refineMIME(sniffed, name string) string {
+	if strings.HasPrefix(sniffed, "application/octet-stream") || strings.HasPrefix(sniffed, "text/plain") {
+		if byExt := mimeFromExt(filepath.Ext(name)); byExt != "" {
+			return byExt
+		}
+	}
+	return sniffed
+}
+
+func mimeFromExt(ext string) string {
+	if ext == "" {
+		return ""
+	}
+	t := mime.TypeByExtension(strings.ToLower(ext))
+	if t == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return t
+	}
+	return mediaType
+}
+
+// modalityForMIME maps a MIME type to the catalog modality a bot needs to
+// accept it. Documents of any other type are parsed to text by Poe.
+func modalityForMIME(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	default:
+		return "text"
+	}
+}
+
+// isURL reports whether s is an http:// or https:// URL.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// checkModalities verifies that bot accepts the input modality of every file
+// before anything is uploaded. Bots missing from the catalog are rejected,
+// since their inputs cannot be checked; callers can skip the check entirely.
+// If the catalog cannot be fetched the check is skipped rather than blocking
+// the query.
+func checkModalities(ctx context.Context, bot string, files []attachment) error {
+	if len(files) == 0 {
+		return nil
+	}
+	all, err := cache.get(ctx)
+	if err != nil {
+		return nil
+	}
+	return validateModalities(all, bot, files)
+}
+
+// validateModalities implements checkModalities against a given catalog.
+func validateModalities(all []models.Model, bot string, files []attachment) error {
+	m := findModel(all, bot)
+	if m == nil {
+		return fmt.Errorf("bot %q is not in the Poe model catalog, so its supported attachment types cannot be checked; skip the modality check to send the files anyway", bot)
+	}
+	inputs := inputModalities(*m)
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	var problems []string
+	needed := map[string]bool{}
+	for _, file := range files {
+		// Unreadable files are reported per file when the upload fails.
+		mimeType, err := sniffMIME(file)
+		if err != nil || mimeType == "" {
+			continue
+		}
+		modality := modalityForMIME(mimeType)
+		if containsString(inputs, modality) {
+			continue
+		}
+		needed[modality] = true
+		problems = append(problems, fmt.Sprintf("%s (%s needs %s input)", file.Source, mimeType, modality))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "bot %q accepts only %s input (modality %q), but these attachments are not supported:",
+		m.ID, strings.Join(inputs, ", "), m.Architecture.Modality)
+	for _, p := range problems {
+		fmt.Fprintf(&sb, "\n- %s", p)
+	}
+	if suggestions := compatibleModels(all, *m, needed); len(suggestions) > 0 {
+		fmt.Fprintf(&sb, "\nModels that accept these attachments: %s", strings.Join(suggestions, ", "))
+	}
+	return fmt.Errorf("%s", sb.String())
+}
+
+// compatibleModels suggests models whose inputs include every needed modality
+// and that still produce text, preferring models from the same owner.
+func compatibleModels(all []models.Model, target models.Model, needed map[string]bool) []string {
+	var same, other []string
+	for _, m := range all {
+		inputs := inputModalities(m)
+		ok := containsString(inputs, "text")
+		for modality := range needed {
+			ok = ok && containsString(inputs, modality)
+		}
+		if out := outputModalities(m); !ok || (len(out) > 0 && !containsString(out, "text")) {
+			continue
+		}
+		if strings.EqualFold(m.OwnedBy, target.OwnedBy) {
+			same = append(same, m.ID)
+		} else {
+			other = append(other, m.ID)
+		}
+	}
+	sort.Strings(same)
+	sort.Strings(other)
+	suggestions := append(same, other...)
+	if len(suggestions) > maxModalitySuggestions {
+		suggestions = suggestions[:maxModalitySuggestions]
+	}
+	return suggestions
+}
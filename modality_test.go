@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n0madic/go-poe/models"
+)
+
+// pngHeader is enough of a PNG file for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestInputModalities(t *testing.T) {
+	tests := []struct {
+		arch models.Architecture
+		want string
+	}{
+		{models.Architecture{Modality: "text,image->text"}, "text,image"},
+		{models.Architecture{Modality: "text->image"}, "text"},
+		{models.Architecture{Modality: "Text, Video -> text"}, "text,video"},
+		{models.Architecture{Modality: "text->text", InputModalities: []string{"text", "audio"}}, "text,audio"},
+		{models.Architecture{}, ""},
+	}
+	for _, tt := range tests {
+		got := strings.Join(inputModalities(models.Model{Architecture: tt.arch}), ",")
+		if got != tt.want {
+			t.Errorf("inputModalities(%+v) = %q, want %q", tt.arch, got, tt.want)
+		}
+	}
+}
+
+func TestSniffMIME(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{write("photo.dat", pngHeader), "image/png"},                 // content wins over extension
+		{write("notes.txt", []byte("hello")), "text/plain"},          // plain text
+		{write("doc.pdf", []byte("%PDF-1.7\n")), "application/pdf"},  // sniffed
+		{write("data.json", []byte(`{"a":1}`)), "application/json"},  // extension refines text/plain
+		{"https://example.com/img/cat.JPG?size=large", "image/jpeg"}, // URL extension
+		{"https://example.com/download", ""},                         // unknown
+	}
+	for _, tt := range tests {
+		got, err := sniffMIME(attachment{Source: tt.file, Name: filepath.Base(tt.file), Path: tt.file})
+		if err != nil {
+			t.Errorf("sniffMIME(%q) error: %v", tt.file, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sniffMIME(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+
+	if _, err := sniffMIME(attachment{Path: filepath.Join(dir, "missing.png")}); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	got, _ := sniffMIME(attachment{Name: "bundle.txt", Data: []byte("package main\n")})
+	if got != "text/plain" {
+		t.Errorf("sniffMIME(in-memory text) = %q, want text/plain", got)
+	}
+}
+
+func TestValidateModalities(t *testing.T) {
+	all := sampleModels()
+	dir := t.TempDir()
+	imgPath := filepath.Join(dir, "shot.png")
+	txtPath := filepath.Join(dir, "notes.txt")
+	os.WriteFile(imgPath, pngHeader, 0o644)
+	os.WriteFile(txtPath, []byte("hello"), 0o644)
+	img := attachment{Source: imgPath, Name: "shot.png", Path: imgPath}
+	txt := attachment{Source: txtPath, Name: "notes.txt", Path: txtPath}
+
+	// gpt-4o accepts text and image.
+	if err := validateModalities(all, "GPT-4o", []attachment{img, txt}); err != nil {
+		t.Errorf("unexpected error for vision model: %v", err)
+	}
+
+	// dall-e-3 accepts text only.
+	err := validateModalities(all, "dall-e-3", []attachment{img, txt})
+	if err == nil {
+		t.Fatal("expected mismatch error for text-only input")
+	}
+	for _, want := range []string{`"dall-e-3"`, "shot.png", "image/png needs image input", "gpt-4o"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q\ngot: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "notes.txt") {
+		t.Errorf("text file should not be reported\ngot: %v", err)
+	}
+
+	// Unknown bots cannot be checked.
+	err = validateModalities(all, "My-Custom-Bot", []attachment{txt})
+	if err == nil || !strings.Contains(err.Error(), "not in the Poe model catalog") {
+		t.Errorf("expected catalog error, got %v", err)
+	}
+}
+
+func TestCompatibleModelsPrefersSameOwner(t *testing.T) {
+	all := sampleModels()
+	dalle := all[2] // OpenAI, text->image
+	got := compatibleModels(all, dalle, map[string]bool{"image": true})
+	if len(got) == 0 || got[0] != "gpt-4o" {
+		t.Errorf("compatibleModels = %v, want gpt-4o first", got)
+	}
+
+	got = compatibleModels(all, dalle, map[string]bool{"video": true})
+	if len(got) != 1 || got[0] != "gemini-2.5-pro" {
+		t.Errorf("compatibleModels(video) = %v, want [gemini-2.5-pro]", got)
+	}
+}
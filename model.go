@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/n0madic/go-poe/models"
+)
+
+// maxModelSuggestions caps how many IDs a failed get_model lookup suggests.
+const maxModelSuggestions = 5
+
+// GetModelArgs defines the input schema for the get_model tool.
+type GetModelArgs struct {
+	Model string `json:"model" jsonschema:"Model ID or display name (case-insensitive)"`
+}
+
+// ModelDetail is every catalog field of one model, returned by get_model.
+// Prices are normalised as in search_models; the catalog's own modality
+// string is kept alongside the parsed sets.
+type ModelDetail struct {
+	ModelInfo
+	Modality          string           `json:"modality,omitempty" jsonschema:"Catalog modality, e.g. text,image->text"`
+	Created           string           `json:"created,omitempty" jsonschema:"When the model was added, in RFC 3339 format"`
+	Root              string           `json:"root,omitempty"`
+	URL               string           `json:"url,omitempty" jsonschema:"Model page on Poe"`
+	ImageURL          string           `json:"image_url,omitempty" jsonschema:"Model avatar"`
+	SupportedFeatures []string         `json:"supported_features,omitempty" jsonschema:"e.g. tools, web_search"`
+	Reasoning         *ModelReasoning  `json:"reasoning,omitempty"`
+	Parameters        []ModelParameter `json:"parameters,omitempty" jsonschema:"Bot-specific parameters a query can set"`
+}
+
+// ModelReasoning describes a model's reasoning support.
+type ModelReasoning struct {
+	Required                bool `json:"required,omitempty" jsonschema:"Reasoning cannot be turned off"`
+	SupportsReasoningEffort bool `json:"supports_reasoning_effort,omitempty"`
+	BudgetMinTokens         int  `json:"budget_min_tokens,omitempty"`
+	BudgetMaxTokens         int  `json:"budget_max_tokens,omitempty"`
+}
+
+// ModelParameter is a bot-specific parameter with its JSON schema.
+type ModelParameter struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      any    `json:"schema,omitempty"`
+	Default     any    `json:"default,omitempty"`
+}
+
+func registerGetModel(server *mcp.Server) {
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "get_model",
+		Description: "Look up one model in the Poe.com catalog by exact ID or display name (case-insensitive) and return every field the catalog provides: modalities, context window, pricing, features, reasoning support and bot parameters. Suggests the closest IDs when nothing matches.",
+	}, handleGetModel)
+}
+
+func handleGetModel(ctx context.Context, req *mcp.CallToolRequest, args GetModelArgs) (*mcp.CallToolResult, *ModelDetail, error) {
+	all, err := cache.get(ctx)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: fmt.Sprintf("Error fetching models: %v", err)},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+	m, err := resolveModel(all, args.Model)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{&mcp.TextContent{Text: err.Error()}},
+			IsError: true,
+		}, nil, nil
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: formatModelDetail(*m)},
+		},
+	}, modelDetail(*m), nil
+}
+
+// resolveModel finds name by ID or display name, case-insensitively. When
+// nothing matches, the error suggests the closest IDs.
+func resolveModel(all []models.Model, name string) (*models.Model, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	if m := findModel(all, name); m != nil {
+		return m, nil
+	}
+	msg := fmt.Sprintf("model %q is not in the Poe model catalog", name)
+	if ids := closestModels(all, name, maxModelSuggestions); len(ids) > 0 {
+		msg += "; did you mean " + strings.Join(ids, ", ") + "?"
+	}
+	return nil, fmt.Errorf("%s", msg)
+}
+
+// closestModels returns the IDs of up to n models most like name: the best
+// search matches if there are any, otherwise the IDs and display names
+// nearest by edit distance.
+func closestModels(all []models.Model, name string, n int) []string {
+	ranked := filterModels(all, SearchModelsArgs{Query: name})
+	if len(ranked) == 0 {
+		target := strings.ToLower(name)
+		distance := func(m models.Model) int {
+			d := editDistance(target, strings.ToLower(m.ID))
+			if m.Metadata.DisplayName != "" {
+				d = min(d, editDistance(target, strings.ToLower(m.Metadata.DisplayName)))
+			}
+			return d
+		}
+		ranked = append([]models.Model(nil), all...)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			di, dj := distance(ranked[i]), distance(ranked[j])
+			if di != dj {
+				return di < dj
+			}
+			return strings.ToLower(ranked[i].ID) < strings.ToLower(ranked[j].ID)
+		})
+	}
+	ids := make([]string, 0, n)
+	for _, m := range ranked {
+		if len(ids) == n {
+			break
+		}
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// modelDetail converts a catalog entry to the get_model result.
+func modelDetail(m models.Model) *ModelDetail {
+	d := &ModelDetail{
+		ModelInfo:         modelInfo(m),
+		Modality:          m.Architecture.Modality,
+		Root:              m.Root,
+		URL:               m.Metadata.URL,
+		SupportedFeatures: m.SupportedFeatures,
+	}
+	if m.Created > 0 {
+		d.Created = time.Unix(m.Created, 0).UTC().Format(time.RFC3339)
+	}
+	if m.Metadata.Image != nil {
+		d.ImageURL = m.Metadata.Image.URL
+	}
+	if r := m.Reasoning; r != nil {
+		d.Reasoning = &ModelReasoning{
+			Required:                r.Required,
+			SupportsReasoningEffort: r.SupportsReasoningEffort,
+		}
+		if r.Budget != nil {
+			d.Reasoning.BudgetMinTokens = r.Budget.MinTokens
+			d.Reasoning.BudgetMaxTokens = r.Budget.MaxTokens
+		}
+	}
+	for _, p := range m.Parameters {
+		d.Parameters = append(d.Parameters, ModelParameter{
+			Name:        p.Name,
+			Description: p.Description,
+			Schema:      rawJSONValue(p.Schema),
+			Default:     rawJSONValue(p.DefaultValue),
+		})
+	}
+	return d
+}
+
+// rawJSONValue decodes raw catalog JSON, or returns nil if it is empty or
+// invalid.
+func rawJSONValue(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// formatModelDetail formats every catalog field of m as readable text.
+// Prices are shown as normalised in the structured result.
+func formatModelDetail(m models.Model) string {
+	d := modelDetail(m)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## %s\n", d.ID)
+	if d.DisplayName != "" && d.DisplayName != d.ID {
+		fmt.Fprintf(&sb, "Display Name: %s\n", d.DisplayName)
+	}
+	if d.Root != "" && d.Root != d.ID {
+		fmt.Fprintf(&sb, "Root: %s\n", d.Root)
+	}
+	fmt.Fprintf(&sb, "Owner: %s\n", d.Owner)
+	if len(d.InputModalities) > 0 {
+		fmt.Fprintf(&sb, "Input: %s\n", strings.Join(d.InputModalities, ", "))
+	}
+	if len(d.OutputModalities) > 0 {
+		fmt.Fprintf(&sb, "Output: %s\n", strings.Join(d.OutputModalities, ", "))
+	}
+	if d.Modality != "" {
+		fmt.Fprintf(&sb, "Modality: %s\n", d.Modality)
+	}
+	if d.Description != "" {
+		fmt.Fprintf(&sb, "Description: %s\n", d.Description)
+	}
+	if d.ContextLength > 0 {
+		fmt.Fprintf(&sb, "Context Length: %d tokens\n", d.ContextLength)
+	}
+	if d.MaxOutputTokens > 0 {
+		fmt.Fprintf(&sb, "Max Output: %d tokens\n", d.MaxOutputTokens)
+	}
+	writePricing(&sb, d.Pricing)
+	if len(d.SupportedFeatures) > 0 {
+		fmt.Fprintf(&sb, "Features: %s\n", strings.Join(d.SupportedFeatures, ", "))
+	}
+	if r := d.Reasoning; r != nil {
+		var parts []string
+		if r.Required {
+			parts = append(parts, "required")
+		}
+		if r.SupportsReasoningEffort {
+			parts = append(parts, "effort levels")
+		}
+		if r.BudgetMaxTokens > 0 {
+			parts = append(parts, fmt.Sprintf("budget %d-%d tokens", r.BudgetMinTokens, r.BudgetMaxTokens))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "supported")
+		}
+		fmt.Fprintf(&sb, "Reasoning: %s\n", strings.Join(parts, ", "))
+	}
+	if d.Created != "" {
+		fmt.Fprintf(&sb, "Created: %s\n", d.Created)
+	}
+	if d.URL != "" {
+		fmt.Fprintf(&sb, "URL: %s\n", d.URL)
+	}
+	if d.ImageURL != "" {
+		fmt.Fprintf(&sb, "Avatar: %s\n", d.ImageURL)
+	}
+	if len(d.Parameters) > 0 {
+		sb.WriteString("Parameters:\n")
+		for _, p := range d.Parameters {
+			fmt.Fprintf(&sb, "- %s", p.Name)
+			if schema, ok := p.Schema.(map[string]any); ok {
+				if t, ok := schema["type"].(string); ok {
+					fmt.Fprintf(&sb, " (%s)", t)
+				}
+			}
+			if p.Default != nil {
+				def, _ := json.Marshal(p.Default)
+				fmt.Fprintf(&sb, ", default %s", def)
+			}
+			if p.Description != "" {
+				fmt.Fprintf(&sb, ": %s", p.Description)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
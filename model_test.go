@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/n0madic/go-poe/models"
+)
+
+// detailedModel has every optional catalog field set.
+func detailedModel() models.Model {
+	str := func(s string) *string { return &s }
+	m := sampleModels()[1] // claude-4.5-sonnet
+	m.Created = 1758844800
+	m.Root = "claude-sonnet-4.5-base"
+	m.SupportedFeatures = []string{"tools", "web_search"}
+	m.Metadata.URL = "https://poe.com/Claude-Sonnet-4.5"
+	m.Metadata.Image = &models.ModelImage{URL: "https://example.com/claude.png"}
+	m.Pricing.InputCacheRead = str("0.0000003")
+	m.Pricing.Request = str("0.01")
+	m.Reasoning = &models.Reasoning{
+		Budget:                  &models.ReasoningBudget{MinTokens: 1024, MaxTokens: 32000},
+		SupportsReasoningEffort: true,
+	}
+	m.Parameters = []models.Parameter{{
+		Name:         "thinking_budget",
+		Description:  "Tokens to spend thinking",
+		Schema:       json.RawMessage(`{"type":"integer","minimum":0}`),
+		DefaultValue: json.RawMessage(`0`),
+	}}
+	return m
+}
+
+func TestResolveModel(t *testing.T) {
+	all := sampleModels()
+	for _, name := range []string{"gpt-4o", "GPT-4O", "  Gemini 2.5 Pro "} {
+		if m, err := resolveModel(all, name); err != nil || m == nil {
+			t.Errorf("resolveModel(%q) = %v, %v", name, m, err)
+		}
+	}
+
+	tests := []struct {
+		name, want string
+	}{
+		// A query that still matches suggests the search results.
+		{"claude sonet", "did you mean claude-4.5-sonnet?"},
+		// Otherwise the nearest IDs by edit distance.
+		{"gpt-40", "did you mean gpt-4o, dall-e-3,"},
+	}
+	for _, tt := range tests {
+		_, err := resolveModel(all, tt.name)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("resolveModel(%q) err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := resolveModel(all, " "); err == nil {
+		t.Error("expected error for an empty name")
+	}
+}
+
+func TestModelDetail(t *testing.T) {
+	d := modelDetail(detailedModel())
+	if d.ID != "claude-4.5-sonnet" || d.ContextLength != 200000 || d.Modality != "text,image->text" {
+		t.Errorf("identity = %+v", d.ModelInfo)
+	}
+	if d.Created != "2025-09-26T00:00:00Z" || d.ImageURL == "" || len(d.SupportedFeatures) != 2 {
+		t.Errorf("metadata = %+v", d)
+	}
+	if r := d.Reasoning; r == nil || !r.SupportsReasoningEffort || r.BudgetMaxTokens != 32000 {
+		t.Errorf("reasoning = %+v", r)
+	}
+	if len(d.Parameters) != 1 || d.Parameters[0].Schema.(map[string]any)["type"] != "integer" || d.Parameters[0].Default != 0.0 {
+		t.Errorf("parameters = %+v", d.Parameters)
+	}
+
+	text := formatModelDetail(detailedModel())
+	for _, want := range []string{
+		"## claude-4.5-sonnet",
+		"Root: claude-sonnet-4.5-base",
+		"Modality: text,image->text",
+		"Context Length: 200000 tokens",
+		"Prompt: $3 per million tokens",
+		"Completion: $15 per million tokens",
+		"Cache Read: $0.3 per million tokens",
+		"Request: $0.01 per request",
+		"Avatar: https://example.com/claude.png",
+		"Features: tools, web_search",
+		"Reasoning: effort levels, budget 1024-32000 tokens",
+		"URL: https://poe.com/Claude-Sonnet-4.5",
+		"- thinking_budget (integer), default 0: Tokens to spend thinking",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("formatModelDetail missing %q\ngot: %s", want, text)
+		}
+	}
+	if strings.Contains(text, "0.000003") {
+		t.Errorf("formatModelDetail shows raw catalog prices\ngot: %s", text)
+	}
+
+	// Older catalog entries only have the top-level context length.
+	m := detailedModel()
+	n := 32000
+	m.ContextWindow, m.ContextLength = nil, &n
+	if text := formatModelDetail(m); !strings.Contains(text, "Context Length: 32000 tokens") {
+		t.Errorf("formatModelDetail missing the top-level context length\ngot: %s", text)
+	}
+}
+
+func TestGetModelTool(t *testing.T) {
+	orig := cache
+	defer func() { cache = orig }()
+	all := sampleModels()
+	all[1] = detailedModel()
+	cache = &modelCache{models: all, fetchedAt: time.Now()}
+
+	ctx := context.Background()
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, nil)
+	registerGetModel(server)
+	st, ct := mcp.NewInMemoryTransports()
+	ss, err := server.Connect(ctx, st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "0.0.0"}, nil).Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+
+	res, err := cs.CallTool(ctx, &mcp.CallToolParams{Name: "get_model", Arguments: map[string]any{"model": "Claude 3.5 Sonnet"}})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	if res.IsError {
+		t.Fatalf("result = %+v", res.Content[0])
+	}
+	data, _ := json.Marshal(res.StructuredContent)
+	var d ModelDetail
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "claude-4.5-sonnet" || d.Pricing == nil || *d.Pricing.PromptPerMTok != 3 || len(d.Parameters) != 1 || d.Reasoning == nil {
+		t.Errorf("structured = %s", data)
+	}
+
+	res, err = cs.CallTool(ctx, &mcp.CallToolParams{Name: "get_model", Arguments: map[string]any{"model": "gemini-pro"}})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	if text := res.Content[0].(*mcp.TextContent).Text; !res.IsError || !strings.Contains(text, "gemini-2.5-pro") {
+		t.Errorf("result = %q, want a suggestion", text)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifOrientationSegment builds a JPEG APP1 segment whose EXIF holds only
+// the Orientation tag, in the given byte order.
+func exifOrientationSegment(order binary.AppendByteOrder, orientation int) []byte {
+	tiff := []byte("II")
+	if order == binary.AppendByteOrder(binary.BigEndian) {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1) // one entry
+	tiff = order.AppendUint16(tiff, exifOrientationTag)
+	tiff = order.AppendUint16(tiff, 3) // SHORT
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0)
+	tiff = order.AppendUint32(tiff, 0) // no next IFD
+
+	payload := append(append([]byte(nil), exifHeader...), tiff...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// orientedJPEG encodes a w x h image, red on the left half and blue on the
+// right, tagged with orientation.
+func orientedJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte(nil), data[:2]...)
+	out = append(out, exifOrientationSegment(binary.BigEndian, orientation)...)
+	return append(out, data[2:]...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		if got := exifOrientation(orientedJPEG(t, 8, 4, o)); got != o {
+			t.Errorf("orientation = %d, want %d", got, o)
+		}
+	}
+	seg := exifOrientationSegment(binary.LittleEndian, 3)
+	jpg := append([]byte{0xFF, 0xD8}, seg...)
+	jpg = append(jpg, 0xFF, 0xD9)
+	if got := exifOrientation(jpg); got != 3 {
+		t.Errorf("little-endian orientation = %d, want 3", got)
+	}
+	if got := exifOrientation(noisyPNG(t, 4, 4, false)); got != 1 {
+		t.Errorf("untagged PNG orientation = %d, want 1", got)
+	}
+	if got := exifOrientation([]byte("not an image")); got != 1 {
+		t.Errorf("non-image orientation = %d, want 1", got)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 2x1 image: red, blue.
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		first       color.NRGBA // pixel at (0, 0)
+	}{
+		{1, 2, 1, red},
+		{2, 2, 1, blue},
+		{3, 2, 1, blue},
+		{4, 2, 1, red},
+		{5, 1, 2, red},
+		{6, 1, 2, red},
+		{7, 1, 2, blue},
+		{8, 1, 2, blue},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		b := got.Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if c := color.NRGBAModel.Convert(got.At(b.Min.X, b.Min.Y)); c != tt.first {
+			t.Errorf("orientation %d: first pixel %v, want %v", tt.orientation, c, tt.first)
+		}
+	}
+}
+
+func TestShrinkImageAppliesOrientation(t *testing.T) {
+	// Stored landscape with orientation 6: displayed portrait, rotated 90°
+	// clockwise, so the red left half ends up on top.
+	data := orientedJPEG(t, 400, 200, 6)
+	out, info, ok := shrinkImage(data, "phone.jpg", 100, 0)
+	if !ok {
+		t.Fatal("expected the image to be resized")
+	}
+	img, _, err := image.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	if b.Dx() != 50 || b.Dy() != 100 || info.Width != 50 || info.Height != 100 {
+		t.Fatalf("size = %dx%d (reported %dx%d), want 50x100", b.Dx(), b.Dy(), info.Width, info.Height)
+	}
+	top, bottom := img.At(25, 10), img.At(25, 90)
+	if r, _, bl, _ := top.RGBA(); r < bl {
+		t.Errorf("top pixel %v, want red", top)
+	}
+	if r, _, bl, _ := bottom.RGBA(); bl < r {
+		t.Errorf("bottom pixel %v, want blue", bottom)
+	}
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/n0madic/go-poe/client"
@@ -14,87 +14,142 @@ import (
 
 // QueryBotArgs defines the input schema for the query_bot tool.
 type QueryBotArgs struct {
-	Bot         string   `json:"bot" jsonschema:"Bot name on Poe.com (e.g. GPT-4o, Claude-4.5-Sonnet, Gemini-2.5-Pro)"`
-	Message     string   `json:"message" jsonschema:"User message to send to the bot"`
-	Files       []string `json:"files,omitempty" jsonschema:"Files to attach (local paths or URLs)"`
-	Temperature *float64 `json:"temperature,omitempty" jsonschema:"Sampling temperature (0.0-2.0)"`
+	Bot               string      `json:"bot" jsonschema:"Bot name on Poe.com (e.g. GPT-4o, Claude-4.5-Sonnet, Gemini-2.5-Pro)"`
+	Message           string      `json:"message" jsonschema:"User message to send to the bot"`
+	Files             []FileInput `json:"files,omitempty" jsonschema:"Files to attach: local paths, directories, glob patterns, URLs or data: URIs, or objects {name, mime_type, base64} with inline content (up to 10 MB each); local paths must lie inside the client's roots or the configured allowed roots"`
+	Temperature       *float64    `json:"temperature,omitempty" jsonschema:"Sampling temperature (0.0-2.0)"`
+	Tools             []string    `json:"tools,omitempty" jsonschema:"Server-side tools to offer a tool-capable bot (search_models, read_file); tool calls are executed locally until the bot answers"`
+	MaxToolIterations int         `json:"max_tool_iterations,omitempty" jsonschema:"Maximum tool call round trips when tools are enabled (default 5)"`
+	FallbackBots      []string    `json:"fallback_bots,omitempty" jsonschema:"Bots to try in order if the requested bot returns an empty response"`
+	NoUploadCache     bool        `json:"no_upload_cache,omitempty" jsonschema:"Re-upload files even if identical content was uploaded recently"`
+	AllowPartial      bool        `json:"allow_partial,omitempty" jsonschema:"Send the query with the files that uploaded successfully instead of failing when some uploads fail"`
+	SkipModalityCheck bool        `json:"skip_modality_check,omitempty" jsonschema:"Send attachments without checking them against the bot's input modalities (needed for bots missing from the model catalog)"`
+	MaxFiles          int         `json:"max_files,omitempty" jsonschema:"Maximum number of files a directory or glob may expand to (default 100)"`
+	MaxBytes          int         `json:"max_bytes,omitempty" jsonschema:"Maximum total bytes a directory or glob may expand to (default 20 MB)"`
+	AttachmentMode    string      `json:"attachment_mode,omitempty" jsonschema:"How text files are sent: upload (default), inline (embed every text file in the message as a fenced code block) auto (inline text files up to inline_limit bytes, upload the rest) or web (fetch URLs locally and attach web pages as readable markdown named after the page title)"`
+	InlineLimit       int         `json:"inline_limit,omitempty" jsonschema:"Largest text file inlined, in bytes (default 16384 in auto mode and 1048576 in inline mode; at most 1048576). Larger text files are uploaded"`
+	MaxImageDimension int         `json:"max_image_dimension,omitempty" jsonschema:"Downscale attached images whose longer side exceeds this many pixels (e.g. 2048)"`
+	MaxImageBytes     int         `json:"max_image_bytes,omitempty" jsonschema:"Re-encode and if needed downscale attached images larger than this many bytes"`
+	KeepMetadata      bool        `json:"keep_metadata,omitempty" jsonschema:"Upload images with their EXIF, XMP and IPTC metadata (GPS location, camera details); stripped by default"`
+	Convert           []string    `json:"convert,omitempty" jsonschema:"Convert these .docx, .xlsx, .pptx and .ipynb files to text locally before sending: file names, paths or glob patterns, or * for all"`
 }
 
-func registerQueryBot(server *mcp.Server) {
-	mcp.AddTool(server, &mcp.Tool{
-		Name:        "query_bot",
-		Description: "Send a message to any Poe.com bot and get the full response",
-	}, handleQueryBot)
+// queryAttempts is how many times an empty response is retried per bot.
+const queryAttempts = 2
+
+// Finish statuses reported in QueryBotResult.
+const (
+	finishComplete  = "complete"
+	finishEmpty     = "empty"
+	finishToolLimit = "tool_limit"
+	finishError     = "error"
+)
+
+// QueryBotResult defines the structured output of the query_bot tool.
+type QueryBotResult struct {
+	Bot           string           `json:"bot" jsonschema:"Bot that produced the response"`
+	Text          string           `json:"text" jsonschema:"Final answer text, without reasoning"`
+	Reasoning     string           `json:"reasoning,omitempty" jsonschema:"Reasoning section emitted by thinking bots"`
+	Attachments   []AttachmentInfo `json:"attachments,omitempty" jsonschema:"Files returned by the bot"`
+	FinishStatus  string           `json:"finish_status" jsonschema:"One of complete, empty, tool_limit, error"`
+	Error         string           `json:"error,omitempty" jsonschema:"Error message when finish_status is not complete"`
+	LatencyMs     int64            `json:"latency_ms" jsonschema:"Time spent querying the bot in milliseconds, excluding uploads"`
+	Attempts      int              `json:"attempts" jsonschema:"Number of queries sent, including retries of empty responses and fallbacks"`
+	FallbackFrom  string           `json:"fallback_from,omitempty" jsonschema:"Requested bot, when a fallback bot answered instead"`
+	ToolSteps     []ToolStep       `json:"tool_steps,omitempty" jsonschema:"Tool loop transcript when tools are enabled"`
+	Usage         *QueryUsage      `json:"usage,omitempty" jsonschema:"Token usage and estimated cost"`
+	DroppedFiles  []DroppedFile    `json:"dropped_files,omitempty" jsonschema:"Files that failed to upload and were not sent (allow_partial only)"`
+	ResizedImages []ResizedImage   `json:"resized_images,omitempty" jsonschema:"Images downscaled or re-encoded before upload, with original and new sizes"`
 }
 
-// uploadFiles uploads each file path or URL and returns the resulting attachments.
-// Strings starting with http:// or https:// are treated as URLs; everything else
-// is treated as a local file path.
-func uploadFiles(ctx context.Context, files []string, key string) ([]types.Attachment, error) {
-	var attachments []types.Attachment
-	for _, path := range files {
-		att, err := uploadSingleFile(ctx, path, key)
-		if err != nil {
-			return nil, err
-		}
-		attachments = append(attachments, *att)
-	}
-	return attachments, nil
+// DroppedFile describes an attachment that was left out of the query.
+type DroppedFile struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
 }
 
-// uploadSingleFile uploads a single file (local path or URL) and returns the attachment.
-func uploadSingleFile(ctx context.Context, path, key string) (*types.Attachment, error) {
-	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-		name := filepath.Base(path)
-		if name == "" || name == "." || name == "/" {
-			name = "file"
-		}
-		return client.UploadFile(ctx, &client.UploadFileOptions{
-			FileURL:  path,
-			FileName: name,
-			APIKey:   key,
-		})
-	}
+// AttachmentInfo describes a file attached to a bot response.
+type AttachmentInfo struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type,omitempty"`
+}
 
-	f, err := os.Open(path)
+func registerQueryBot(server *mcp.Server) {
+	// FileInput accepts a string or an object, which schema inference cannot
+	// express, so its schema is supplied explicitly.
+	inputSchema, err := inputSchemaFor[QueryBotArgs]()
 	if err != nil {
-		return nil, fmt.Errorf("file %q: %w", path, err)
+		panic(fmt.Sprintf("query_bot input schema: %v", err))
 	}
-	defer f.Close()
-
-	return client.UploadFile(ctx, &client.UploadFileOptions{
-		File:     f,
-		FileName: filepath.Base(path),
-		APIKey:   key,
-	})
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "query_bot",
+		Description: "Send a message to any Poe.com bot and get the full response. Reasoning output from thinking bots is separated from the final answer.",
+		InputSchema: inputSchema,
+	}, handleQueryBot)
 }
 
-func handleQueryBot(ctx context.Context, req *mcp.CallToolRequest, args QueryBotArgs) (*mcp.CallToolResult, any, error) {
+func handleQueryBot(ctx context.Context, req *mcp.CallToolRequest, args QueryBotArgs) (*mcp.CallToolResult, *QueryBotResult, error) {
+	result := &QueryBotResult{Bot: args.Bot}
 	if apiKey == "" {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				&mcp.TextContent{Text: "POE_API_KEY environment variable is required"},
-			},
-			IsError: true,
-		}, nil, nil
+		return queryError(result, "POE_API_KEY environment variable is required")
+	}
+
+	// Attachments and the read_file tool share one sandbox.
+	var sb *sandbox
+	if len(args.Files) > 0 || len(args.Tools) > 0 {
+		sb = sandboxForRequest(ctx, req)
 	}
 
-	var attachments []types.Attachment
+	message := args.Message
+	var (
+		attachments []types.Attachment
+		textBytes   int64 // uploaded text files, parsed into the prompt by Poe
+	)
 	if len(args.Files) > 0 {
-		var err error
-		attachments, err = uploadFiles(ctx, args.Files, apiKey)
+		mode, err := parseAttachMode(args.AttachmentMode)
 		if err != nil {
-			return &mcp.CallToolResult{
-				Content: []mcp.Content{
-					&mcp.TextContent{Text: fmt.Sprintf("Error uploading files: %v", err)},
-				},
-				IsError: true,
-			}, nil, nil
+			return queryError(result, err.Error())
+		}
+		files := make([]string, len(args.Files))
+		for i, f := range args.Files {
+			files[i] = f.entry()
+		}
+		uploaded, err := uploadFiles(ctx, files, apiKey, uploadOptions{
+			NoCache:           args.NoUploadCache,
+			Bot:               args.Bot,
+			SkipModalityCheck: args.SkipModalityCheck,
+			MaxFiles:          args.MaxFiles,
+			MaxBytes:          args.MaxBytes,
+			Mode:              mode,
+			InlineLimit:       args.InlineLimit,
+			Sandbox:           sb,
+			MaxImageDimension: args.MaxImageDimension,
+			MaxImageBytes:     args.MaxImageBytes,
+			KeepMetadata:      args.KeepMetadata,
+			Convert:           args.Convert,
+		})
+		var accErr *accessError
+		if errors.As(err, &accErr) {
+			return queryError(result, err.Error())
 		}
+		if err != nil {
+			var upErr *uploadError
+			if !args.AllowPartial || !errors.As(err, &upErr) || (len(uploaded.Attachments) == 0 && uploaded.Inline == "") {
+				return queryError(result, fmt.Sprintf("Error uploading files: %v", err))
+			}
+			for _, f := range upErr.Failures {
+				result.DroppedFiles = append(result.DroppedFiles, DroppedFile{Path: f.Path, Error: f.Err.Error()})
+			}
+		}
+		attachments = uploaded.Attachments
+		message += uploaded.Inline
+		result.ResizedImages = uploaded.Resized
+		textBytes = uploaded.TextBytes
 	}
 
 	messages := []types.ProtocolMessage{
-		{Role: "user", Content: args.Message, Attachments: attachments},
+		{Role: "user", Content: message, Attachments: attachments},
 	}
 
 	queryReq := &types.QueryRequest{
@@ -106,30 +161,68 @@ func handleQueryBot(ctx context.Context, req *mcp.CallToolRequest, args QueryBot
 		Temperature: args.Temperature,
 	}
 
-	response, err := client.GetFinalResponse(ctx, queryReq, args.Bot, apiKey, nil)
+	// Each bot's requests are priced and recorded under that bot; failed and
+	// empty attempts were still sent, so they count too.
+	var (
+		bots   []string
+		meters = map[string]*usageMeter{}
+	)
+	attempt := func(ctx context.Context, bot string) (*client.BotResponse, []ToolStep, error) {
+		meter := meters[bot]
+		if meter == nil {
+			meter = &usageMeter{attachedText: textBytes}
+			meters[bot] = meter
+			bots = append(bots, bot)
+		}
+		return queryOnce(ctx, queryReq, bot, args, sb, meter)
+	}
+	start := time.Now()
+	response, steps, err := queryWithFallback(ctx, append([]string{args.Bot}, args.FallbackBots...), attempt, result)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.ToolSteps = steps
+	var usages []*QueryUsage
+	for _, bot := range bots {
+		if u := meters[bot].usage(ctx, bot); u != nil {
+			sessionUsage.record(bot, u)
+			usages = append(usages, u)
+		}
+	}
+	result.Usage = sumUsage(usages)
 	if err != nil {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				&mcp.TextContent{Text: fmt.Sprintf("Error querying bot %q: %v", args.Bot, err)},
-			},
-			IsError: true,
-		}, nil, nil
+		if len(steps) > 0 {
+			err = fmt.Errorf("%w\n\nTool transcript:\n%s", err, formatTranscript(steps))
+		}
+		out, res, _ := queryError(result, fmt.Sprintf("Error querying bot %q: %v", result.Bot, err))
+		if errors.Is(err, errToolLimit) {
+			res.FinishStatus = finishToolLimit
+		}
+		return out, res, nil
 	}
 
-	if response.Text == "" && len(response.Attachments) == 0 {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				&mcp.TextContent{Text: fmt.Sprintf("Bot %q returned an empty response", args.Bot)},
-			},
-			IsError: true,
-		}, nil, nil
+	if isEmptyResponse(response) {
+		msg := fmt.Sprintf("Bot %q returned an empty response", args.Bot)
+		if len(args.FallbackBots) > 0 {
+			msg = fmt.Sprintf("Bot %q and fallback bots %s returned empty responses", args.Bot, strings.Join(args.FallbackBots, ", "))
+		}
+		out, res, _ := queryError(result, msg)
+		res.FinishStatus = finishEmpty
+		return out, res, nil
 	}
 
-	text := response.Text
+	reasoning, text := splitThinking(response.Text)
+	result.Text = text
+	result.Reasoning = reasoning
+	result.FinishStatus = finishComplete
+
 	if len(response.Attachments) > 0 {
 		var links strings.Builder
 		links.WriteString("\n\nAttachments:\n")
 		for _, att := range response.Attachments {
+			result.Attachments = append(result.Attachments, AttachmentInfo{
+				Name:        att.Name,
+				URL:         att.URL,
+				ContentType: att.ContentType,
+			})
 			name := att.Name
 			if name == "" {
 				name = att.URL
@@ -143,9 +236,104 @@ func handleQueryBot(ctx context.Context, req *mcp.CallToolRequest, args QueryBot
 		text += links.String()
 	}
 
+	content := []mcp.Content{
+		&mcp.TextContent{Text: text},
+	}
+	if reasoning != "" {
+		content = append(content, &mcp.TextContent{Text: "Reasoning:\n" + reasoning})
+	}
+	if len(steps) > 0 {
+		content = append(content, &mcp.TextContent{Text: "Tool transcript:\n" + formatTranscript(steps)})
+	}
+	if result.Usage != nil {
+		content = append(content, &mcp.TextContent{Text: "Usage: " + result.Usage.String()})
+	}
+	if len(result.DroppedFiles) > 0 {
+		var note strings.Builder
+		fmt.Fprintf(&note, "Warning: %d file(s) could not be uploaded and were NOT sent to the bot:", len(result.DroppedFiles))
+		for _, f := range result.DroppedFiles {
+			fmt.Fprintf(&note, "\n- %s: %s", f.Path, f.Error)
+		}
+		content = append(content, &mcp.TextContent{Text: note.String()})
+	}
+	if result.FallbackFrom != "" {
+		content = append(content, &mcp.TextContent{Text: fmt.Sprintf("Note: %q returned no response; answered by fallback bot %q.", result.FallbackFrom, result.Bot)})
+	}
+	if len(result.ResizedImages) > 0 {
+		var note strings.Builder
+		note.WriteString("Resized images:")
+		for _, r := range result.ResizedImages {
+			fmt.Fprintf(&note, "\n- %s", r)
+		}
+		content = append(content, &mcp.TextContent{Text: note.String()})
+	}
+
+	return &mcp.CallToolResult{
+		Content: content,
+	}, result, nil
+}
+
+// attemptFunc sends one query to bot.
+type attemptFunc func(ctx context.Context, bot string) (*client.BotResponse, []ToolStep, error)
+
+// queryWithFallback queries each of bots in turn, retrying empty responses up
+// to queryAttempts times per bot, until one answers. Errors are returned
+// without trying further bots. It records the answering bot, the number of
+// attempts and the fallback origin in result.
+func queryWithFallback(ctx context.Context, bots []string, attempt attemptFunc, result *QueryBotResult) (*client.BotResponse, []ToolStep, error) {
+	var (
+		response *client.BotResponse
+		steps    []ToolStep
+		err      error
+	)
+	for _, bot := range bots {
+		result.Bot = bot
+		for range queryAttempts {
+			result.Attempts++
+			response, steps, err = attempt(ctx, bot)
+			if err != nil {
+				return nil, steps, err
+			}
+			if !isEmptyResponse(response) {
+				if bot != bots[0] {
+					result.FallbackFrom = bots[0]
+				}
+				return response, steps, nil
+			}
+			if ctx.Err() != nil {
+				return nil, steps, ctx.Err()
+			}
+		}
+	}
+	result.Bot = bots[0]
+	return response, steps, nil
+}
+
+// queryOnce sends a single query to bot, running the tool loop when tools are
+// enabled.
+func queryOnce(ctx context.Context, req *types.QueryRequest, bot string, args QueryBotArgs, sb *sandbox, meter *usageMeter) (*client.BotResponse, []ToolStep, error) {
+	if len(args.Tools) > 0 {
+		// Start every attempt from a clean tool history.
+		req.ToolCalls, req.ToolResults = nil, nil
+		return runToolLoop(ctx, req, bot, apiKey, args.Tools, args.MaxToolIterations, sb, meter)
+	}
+	resp, err := client.GetFinalResponse(ctx, req, bot, apiKey, nil)
+	meter.observe(req, resp)
+	return resp, nil, err
+}
+
+func isEmptyResponse(resp *client.BotResponse) bool {
+	return resp == nil || (resp.Text == "" && len(resp.Attachments) == 0)
+}
+
+// queryError builds an error tool result, recording msg in the structured output.
+func queryError(result *QueryBotResult, msg string) (*mcp.CallToolResult, *QueryBotResult, error) {
+	result.FinishStatus = finishError
+	result.Error = msg
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
-			&mcp.TextContent{Text: text},
+			&mcp.TextContent{Text: msg},
 		},
-	}, nil, nil
+		IsError: true,
+	}, result, nil
 }
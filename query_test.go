@@ -2,45 +2,110 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/n0madic/go-poe/client"
 )
 
-func TestUploadFilesValidation(t *testing.T) {
+func TestRegisterQueryBotSchema(t *testing.T) {
+	// AddTool panics if the input or output schema cannot be derived.
 	ctx := context.Background()
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, nil)
+	registerQueryBot(server)
 
-	tests := []struct {
-		name    string
-		files   []string
-		wantErr string
-	}{
-		{
-			name:    "non-existent local file",
-			files:   []string{"/no/such/file.txt"},
-			wantErr: "no such file or directory",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			_, err := uploadFiles(ctx, tt.files, "fake-key")
-			if err == nil {
-				t.Fatal("expected error, got nil")
-			}
-			if !strings.Contains(err.Error(), tt.wantErr) {
-				t.Errorf("error = %q, want substring %q", err.Error(), tt.wantErr)
-			}
-		})
+	st, ct := mcp.NewInMemoryTransports()
+	ss, err := server.Connect(ctx, st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "0.0.0"}, nil).Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	tools, err := cs.ListTools(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(tools.Tools[0].OutputSchema)
+	var schema struct {
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"bot", "text", "attachments", "finish_status", "latency_ms", "attempts", "fallback_from"} {
+		if _, ok := schema.Properties[field]; !ok {
+			t.Errorf("output schema is missing %q", field)
+		}
 	}
 }
 
-func TestUploadFilesEmptySlice(t *testing.T) {
-	ctx := context.Background()
-	attachments, err := uploadFiles(ctx, nil, "fake-key")
+func TestQueryWithFallback(t *testing.T) {
+	answers := map[string][]string{
+		"Empty-Bot": {"", ""},
+		"Flaky-Bot": {"", "second try"},
+	}
+	var sent []string
+	attempt := func(ctx context.Context, bot string) (*client.BotResponse, []ToolStep, error) {
+		sent = append(sent, bot)
+		if bot == "Broken-Bot" {
+			return nil, nil, errors.New("boom")
+		}
+		text := answers[bot][0]
+		answers[bot] = answers[bot][1:]
+		return &client.BotResponse{Text: text}, nil, nil
+	}
+
+	result := &QueryBotResult{}
+	resp, _, err := queryWithFallback(context.Background(), []string{"Empty-Bot", "Flaky-Bot"}, attempt, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "second try" || result.Bot != "Flaky-Bot" || result.FallbackFrom != "Empty-Bot" || result.Attempts != 4 {
+		t.Errorf("text %q, bot %q, fallback from %q, attempts %d", resp.Text, result.Bot, result.FallbackFrom, result.Attempts)
+	}
+	if strings.Join(sent, ",") != "Empty-Bot,Empty-Bot,Flaky-Bot,Flaky-Bot" {
+		t.Errorf("queries sent to %v", sent)
+	}
+
+	// Errors are not retried on fallback bots.
+	sent, result = nil, &QueryBotResult{}
+	if _, _, err := queryWithFallback(context.Background(), []string{"Broken-Bot", "Flaky-Bot"}, attempt, result); err == nil {
+		t.Error("expected the error to be returned")
+	}
+	if len(sent) != 1 || result.Attempts != 1 || result.FallbackFrom != "" {
+		t.Errorf("sent %v, attempts %d, fallback from %q", sent, result.Attempts, result.FallbackFrom)
+	}
+}
+
+func TestHandleQueryBotStructuredError(t *testing.T) {
+	origKey := apiKey
+	defer func() { apiKey = origKey }()
+	apiKey = ""
+
+	res, out, err := handleQueryBot(context.Background(), nil, QueryBotArgs{Bot: "GPT-4o", Message: "hi"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(attachments) != 0 {
-		t.Errorf("expected 0 attachments, got %d", len(attachments))
+	if !res.IsError {
+		t.Error("expected IsError result")
+	}
+	if out == nil {
+		t.Fatal("expected structured output")
+	}
+	if out.FinishStatus != finishError {
+		t.Errorf("finish_status = %q, want %q", out.FinishStatus, finishError)
+	}
+	if out.Bot != "GPT-4o" {
+		t.Errorf("bot = %q, want GPT-4o", out.Bot)
+	}
+	if !strings.Contains(out.Error, "POE_API_KEY") {
+		t.Errorf("error = %q, want POE_API_KEY message", out.Error)
 	}
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/n0madic/go-poe/models"
+)
+
+// Field weights for query matching: a word found in the ID or display name
+// counts for more than one found only in the owner or description.
+const (
+	idWeight          = 1.0
+	displayNameWeight = 1.0
+	ownerWeight       = 0.6
+	descriptionWeight = 0.4
+)
+
+// Match strengths for a query word within one field.
+const (
+	exactTokenMatch = 1.0  // the word is a whole token: "sonnet"
+	prefixMatch     = 0.85 // the word starts a token: "son"
+	substringMatch  = 0.7  // the word appears across separators: "45" in "4.5"
+	fuzzyMatch      = 0.5  // within the edit distance; less per edit
+)
+
+// searchText is a field split into lowercase alphanumeric tokens. compact is
+// the tokens joined without separators, so "4.5", "4-5" and "45" all match.
+type searchText struct {
+	tokens  []string
+	compact string
+}
+
+func newSearchText(s string) searchText {
+	tokens := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return searchText{tokens: tokens, compact: strings.Join(tokens, "")}
+}
+
+// queryScore rates how well m matches query, from 0 when some query word
+// matches none of the fields to 1 when the query is the model's ID or display
+// name apart from case and separators. Every word must match, as before
+// ranking was added; words may be misspelt by an edit or two.
+func queryScore(m models.Model, query string) float64 {
+	q := newSearchText(query)
+	if len(q.tokens) == 0 {
+		return 1
+	}
+	id, name := newSearchText(m.ID), newSearchText(m.Metadata.DisplayName)
+	if q.compact == id.compact || q.compact == name.compact {
+		return 1
+	}
+	fields := []struct {
+		text   searchText
+		weight float64
+	}{
+		{id, idWeight},
+		{name, displayNameWeight},
+		{newSearchText(m.OwnedBy), ownerWeight},
+		{newSearchText(m.Description), descriptionWeight},
+	}
+	var total float64
+	var words int
+	for _, word := range strings.Fields(query) {
+		w := newSearchText(word).compact
+		if w == "" {
+			continue
+		}
+		best := 0.0
+		for _, f := range fields {
+			best = max(best, f.weight*wordMatch(f.text, w))
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+		words++
+	}
+	// Leave the top of the range to exact ID and display-name matches.
+	score := 0.9 * total / float64(words)
+	return math.Round(score*1000) / 1000
+}
+
+// wordMatch returns the strength of the best match of the compacted query
+// word w in t, or 0 if there is none.
+func wordMatch(t searchText, w string) float64 {
+	best := 0.0
+	for _, tok := range t.tokens {
+		switch {
+		case tok == w:
+			return exactTokenMatch
+		case strings.HasPrefix(tok, w):
+			best = max(best, prefixMatch)
+		}
+	}
+	if best > 0 {
+		return best
+	}
+	if strings.Contains(t.compact, w) {
+		return substringMatch
+	}
+	allowed := allowedEdits(w)
+	if allowed == 0 {
+		return 0
+	}
+	for _, tok := range t.tokens {
+		d := editDistance(w, tok)
+		if n := len([]rune(w)); len([]rune(tok)) > n {
+			// Typos in a partial word: "sonn" for "sonnet".
+			d = min(d, editDistance(w, string([]rune(tok)[:n])))
+		}
+		if d <= allowed {
+			best = max(best, fuzzyMatch-0.1*float64(d))
+		}
+	}
+	return best
+}
+
+// allowedEdits is the edit distance tolerated for a query word. Short words
+// and words with digits must match exactly, so "gpt5" does not find "gpt-4o".
+func allowedEdits(w string) int {
+	for _, r := range w {
+		if unicode.IsDigit(r) {
+			return 0
+		}
+	}
+	switch n := len([]rune(w)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/n0madic/go-poe/models"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"sonet", "sonnet", 1},
+		{"claud", "claude", 1},
+		{"gemnii", "gemini", 2},
+		{"kitten", "sitting", 3},
+		{"ü", "u", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestQueryScore(t *testing.T) {
+	ms := sampleModels()
+	gpt, claude, dalle := ms[0], ms[1], ms[2]
+	tests := []struct {
+		name  string
+		m     models.Model
+		query string
+		want  func(float64) bool
+	}{
+		{"exact id", claude, "claude-4.5-sonnet", func(s float64) bool { return s == 1 }},
+		{"separators", claude, "Claude 4-5 Sonnet", func(s float64) bool { return s == 1 }},
+		{"exact display name", gpt, "gpt 4o", func(s float64) bool { return s == 1 }},
+		{"typos", claude, "claud sonet", func(s float64) bool { return s > 0 && s < 0.9 }},
+		{"digits are exact", gpt, "gpt5", func(s float64) bool { return s == 0 }},
+		{"every word must match", gpt, "gpt sonnet", func(s float64) bool { return s == 0 }},
+		{"description", dalle, "generation", func(s float64) bool { return s > 0 && s < 0.5 }},
+	}
+	for _, tt := range tests {
+		if got := queryScore(tt.m, tt.query); !tt.want(got) {
+			t.Errorf("%s: queryScore(%s, %q) = %v", tt.name, tt.m.ID, tt.query, got)
+		}
+	}
+
+	// An owner match outranks a description match.
+	if id, desc := queryScore(gpt, "openai"), queryScore(dalle, "image"); id <= desc {
+		t.Errorf("owner match %v should outrank description match %v", id, desc)
+	}
+}
+
+func TestFilterModelsRanked(t *testing.T) {
+	all := append(sampleModels(),
+		models.Model{ID: "gpt-4o-mini", OwnedBy: "OpenAI", Metadata: models.ModelMetadata{DisplayName: "GPT-4o-Mini"}},
+		models.Model{ID: "assistant", OwnedBy: "Poe", Description: "Routes to GPT-4o or Claude"},
+	)
+	tests := []struct {
+		query, want string
+	}{
+		{"claud sonet", "claude-4.5-sonnet"},
+		{"claude 4.5", "claude-4.5-sonnet"},
+		{"gpt", "gpt-4o,gpt-4o-mini,assistant"},
+		{"gpt-4o mini", "gpt-4o-mini"},
+		{"claude", "claude-4.5-sonnet,assistant"},
+	}
+	for _, tt := range tests {
+		if got := modelIDs(filterModels(all, SearchModelsArgs{Query: tt.query})); got != tt.want {
+			t.Errorf("query %q = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	// An explicit sort overrides relevance.
+	page, err := searchModels(all, SearchModelsArgs{Query: "gpt", SortBy: "id", Order: "desc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := modelIDs(page.Models); got != "gpt-4o-mini,gpt-4o,assistant" {
+		t.Errorf("sorted = %s", got)
+	}
+}
+
+func TestHandleSearchModelsScore(t *testing.T) {
+	orig := cache
+	defer func() { cache = orig }()
+	cache = &modelCache{models: sampleModels(), fetchedAt: time.Now()}
+
+	_, out, _ := handleSearchModels(context.Background(), nil, SearchModelsArgs{Query: "openai"})
+	if len(out.Models) != 2 || out.Models[0].Score == 0 || out.Models[0].Score >= 1 {
+		t.Errorf("models = %+v", out.Models)
+	}
+	_, out, _ = handleSearchModels(context.Background(), nil, SearchModelsArgs{Query: "GPT-4o"})
+	if len(out.Models) != 1 || out.Models[0].Score != 1 {
+		t.Errorf("models = %+v", out.Models)
+	}
+	_, out, _ = handleSearchModels(context.Background(), nil, SearchModelsArgs{})
+	if out.Models[0].Score != 0 {
+		t.Errorf("score without a query = %v", out.Models[0].Score)
+	}
+}
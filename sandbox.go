@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// defaultDenyPatterns match credentials and other secrets that are never read,
+// even inside an allowed root. A pattern without a slash matches any single
+// path segment, so ".ssh" covers everything below a .ssh directory; a pattern
+// with slashes matches a run of consecutive segments.
+var defaultDenyPatterns = []string{
+	".ssh", ".gnupg", ".aws", ".azure", ".kube", ".password-store",
+	".config/gcloud", ".docker/config.json",
+	".netrc", ".npmrc", ".pypirc", ".git-credentials", ".pgpass",
+	".env", ".env.*",
+	"id_rsa*", "id_dsa*", "id_ecdsa*", "id_ed25519*",
+	"*.pem", "*.key", "*.p12", "*.pfx", "*.kdbx",
+}
+
+// accessError reports a local path the sandbox refuses to read.
+type accessError struct {
+	Path   string
+	Reason string
+}
+
+func (e *accessError) Error() string {
+	return fmt.Sprintf("access denied: %q %s", e.Path, e.Reason)
+}
+
+// sandbox restricts local file access to a set of root directories and
+// rejects paths matching deny patterns. Roots are canonical, so symlinks and
+// ".." cannot be used to escape them. A nil *sandbox allows everything.
+type sandbox struct {
+	roots []string
+	deny  []string
+}
+
+// newSandbox canonicalises roots; roots that do not exist are ignored.
+func newSandbox(roots, deny []string) *sandbox {
+	s := &sandbox{deny: deny}
+	for _, r := range roots {
+		abs, err := filepath.Abs(r)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			s.roots = append(s.roots, resolved)
+		}
+	}
+	return s
+}
+
+// sandboxForRequest builds the sandbox for a tool call. Roots come from the
+// MCP client when it supports them, then from the config, and finally default
+// to the server's working directory.
+func sandboxForRequest(ctx context.Context, req *mcp.CallToolRequest) *sandbox {
+	roots := clientRoots(ctx, req)
+	if len(roots) == 0 {
+		roots = cfg.AllowedRoots
+	}
+	if len(roots) == 0 {
+		if wd, err := os.Getwd(); err == nil {
+			roots = []string{wd}
+		}
+	}
+	return newSandbox(roots, denyPatterns())
+}
+
+// denyPatterns returns the default deny patterns plus those from the config.
+func denyPatterns() []string {
+	return append(append([]string(nil), defaultDenyPatterns...), cfg.DenyPatterns...)
+}
+
+// clientRoots lists the file:// roots exposed by the MCP client, or nil if the
+// client does not support roots.
+func clientRoots(ctx context.Context, req *mcp.CallToolRequest) []string {
+	if req == nil || req.Session == nil {
+		return nil
+	}
+	params := req.Session.InitializeParams()
+	if params == nil || params.Capabilities == nil || params.Capabilities.RootsV2 == nil {
+		return nil
+	}
+	res, err := req.Session.ListRoots(ctx, nil)
+	if err != nil {
+		return nil
+	}
+	var roots []string
+	for _, r := range res.Roots {
+		if p, ok := fileURIPath(r.URI); ok {
+			roots = append(roots, p)
+		}
+	}
+	return roots
+}
+
+// fileURIPath converts a file:// URI to a local path.
+func fileURIPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return "", false
+	}
+	return filepath.FromSlash(u.Path), true
+}
+
+// resolve returns the canonical form of path, or an *accessError if it lies
+// outside every root or matches a deny pattern. Paths that do not exist are
+// checked as they would resolve once created and, if allowed, returned
+// unchanged so the caller reports them as missing files; a refused path is
+// never reported as missing, so its existence is not revealed.
+func (s *sandbox) resolve(path string) (string, error) {
+	if s == nil {
+		return path, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	missing := errors.Is(err, fs.ErrNotExist)
+	if missing {
+		resolved = resolveMissing(abs)
+	} else if err != nil {
+		return "", err
+	}
+	if !s.inRoots(resolved) {
+		reason := "is outside the allowed roots"
+		if len(s.roots) > 0 {
+			reason += " (" + strings.Join(s.roots, ", ") + ")"
+		}
+		return "", &accessError{Path: path, Reason: reason}
+	}
+	if pattern, ok := deniedPath(resolved, s.deny); ok {
+		return "", &accessError{Path: path, Reason: fmt.Sprintf("matches the deny pattern %q", pattern)}
+	}
+	if missing {
+		return path, nil
+	}
+	return resolved, nil
+}
+
+// resolveMissing canonicalises the clean absolute path abs, which does not
+// exist, by resolving its longest existing ancestor and appending the rest.
+func resolveMissing(abs string) string {
+	var rest []string
+	dir := abs
+	for {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+		dir = parent
+	}
+}
+
+// denies reports whether p matches a deny pattern, without resolving it.
+func (s *sandbox) denies(p string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := deniedPath(p, s.deny)
+	return ok
+}
+
+func (s *sandbox) inRoots(p string) bool {
+	for _, root := range s.roots {
+		if within(root, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether p is root or lies below it. Both must be canonical.
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// deniedPath returns the first pattern matching a run of consecutive segments
+// of p.
+func deniedPath(p string, patterns []string) (string, bool) {
+	segs := strings.Split(strings.Trim(filepath.ToSlash(p), "/"), "/")
+	for _, pattern := range patterns {
+		n := strings.Count(pattern, "/") + 1
+		for i := 0; i+n <= len(segs); i++ {
+			if matchGlob(pattern, strings.Join(segs[i:i+n], "/")) {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
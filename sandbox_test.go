@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeniedPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/home/u/.ssh/id_rsa", true},
+		{"/home/u/.ssh/config", true},
+		{"/srv/app/.env", true},
+		{"/srv/app/.env.production", true},
+		{"/home/u/.docker/config.json", true},
+		{"/srv/app/config.json", false},
+		{"/srv/app/tls/server.key", true},
+		{"/srv/app/main.go", false},
+		{"/srv/app/environment.go", false},
+	}
+	for _, tt := range tests {
+		if _, got := deniedPath(tt.path, defaultDenyPatterns); got != tt.want {
+			t.Errorf("deniedPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSandboxResolve(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	writeTree(t, base, map[string]string{
+		"root/ok.txt":   "ok",
+		"root/.env":     "SECRET=1",
+		"outside.txt":   "secret",
+		"root/sub/a.go": "package a",
+	})
+	if err := os.Symlink(filepath.Join(base, "outside.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	sb := newSandbox([]string{root}, defaultDenyPatterns)
+
+	if _, err := sb.resolve(filepath.Join(root, "ok.txt")); err != nil {
+		t.Errorf("file inside root rejected: %v", err)
+	}
+	if _, err := sb.resolve(filepath.Join(root, "sub")); err != nil {
+		t.Errorf("directory inside root rejected: %v", err)
+	}
+
+	denied := []string{
+		filepath.Join(base, "outside.txt"),
+		filepath.Join(root, "..", "outside.txt"),
+		filepath.Join(root, "link.txt"),
+		filepath.Join(root, ".env"),
+		// Missing paths are checked too, so refusals do not reveal whether
+		// a file exists.
+		filepath.Join(base, "missing.txt"),
+		filepath.Join(base, "nodir", "missing.txt"),
+		filepath.Join(root, ".ssh", "id_rsa"),
+		filepath.Join(root, "missing.pem"),
+	}
+	for _, p := range denied {
+		_, err := sb.resolve(p)
+		var accErr *accessError
+		if !errors.As(err, &accErr) {
+			t.Errorf("resolve(%q) error = %v, want *accessError", p, err)
+		}
+	}
+
+	// Missing files pass through so the upload reports them.
+	missing := filepath.Join(root, "missing.txt")
+	if got, err := sb.resolve(missing); err != nil || got != missing {
+		t.Errorf("resolve(missing) = %q, %v", got, err)
+	}
+
+	var nilSandbox *sandbox
+	if got, err := nilSandbox.resolve("/etc/passwd"); err != nil || got != "/etc/passwd" {
+		t.Errorf("nil sandbox resolve = %q, %v", got, err)
+	}
+}
+
+func TestPrepareAttachmentsSandbox(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "proj")
+	writeTree(t, base, map[string]string{
+		"proj/main.go":     "package main",
+		"proj/.env":        "SECRET=1",
+		"proj/.ssh/id_rsa": "key",
+		"other/x.txt":      "x",
+	})
+	opts := uploadOptions{Sandbox: newSandbox([]string{root}, defaultDenyPatterns)}
+
+	atts, err := prepareAttachments([]string{root}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(atts) != 1 || atts[0].Name != "main.go" {
+		t.Errorf("denied files not skipped during expansion: %+v", atts)
+	}
+
+	for _, entry := range []string{
+		filepath.Join(base, "other", "x.txt"),
+		filepath.Join(base, "other"),
+		filepath.Join(base, "other", "*.txt"),
+		filepath.Join(root, ".env"),
+		filepath.Join(base, "missing"),
+		filepath.Join(base, "missing", "*.txt"),
+	} {
+		_, err := prepareAttachments([]string{entry}, opts)
+		var accErr *accessError
+		if !errors.As(err, &accErr) {
+			t.Errorf("prepareAttachments(%q) error = %v, want *accessError", entry, err)
+		}
+	}
+}
+
+func TestPrepareAttachmentsResolvedPaths(t *testing.T) {
+	base := t.TempDir()
+	real := filepath.Join(base, "real")
+	writeTree(t, real, map[string]string{"pkg/a.go": "package a", "pkg/b.go": "package b"})
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	resolvedReal, err := filepath.EvalSymlinks(filepath.Join(real, "pkg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := uploadOptions{Sandbox: newSandbox([]string{base}, defaultDenyPatterns), Mode: attachInline}
+
+	// Expanded files are read from the path the sandbox approved, while the
+	// source keeps the path as walked.
+	walked := filepath.Join(link, "pkg")
+	atts, err := prepareAttachments([]string{walked}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(atts) != 2 {
+		t.Fatalf("attachments = %+v, want 2", atts)
+	}
+	for _, a := range atts {
+		if filepath.Dir(a.Path) != resolvedReal {
+			t.Errorf("path = %q, want it under %q", a.Path, resolvedReal)
+		}
+		if filepath.Dir(a.Source) != walked {
+			t.Errorf("source = %q, want it under %q", a.Source, walked)
+		}
+	}
+}
+
+func TestPrepareAttachmentsSymlinkedDir(t *testing.T) {
+	base := t.TempDir()
+	real := filepath.Join(base, "real")
+	writeTree(t, real, map[string]string{"a.go": "package a", "sub/b.go": "package b"})
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	// The walk follows the symlinked argument itself, with and without a
+	// sandbox, and names the files under the argument as given.
+	for _, sb := range []*sandbox{nil, newSandbox([]string{base}, defaultDenyPatterns)} {
+		atts, err := prepareAttachments([]string{link}, uploadOptions{Sandbox: sb, Mode: attachInline})
+		if err != nil {
+			t.Fatalf("sandbox %v: unexpected error: %v", sb != nil, err)
+		}
+		var sources []string
+		for _, a := range atts {
+			sources = append(sources, a.Source)
+		}
+		want := []string{filepath.Join(link, "a.go"), filepath.Join(link, "sub", "b.go")}
+		if strings.Join(sources, ",") != strings.Join(want, ",") {
+			t.Errorf("sandbox %v: sources = %v, want %v", sb != nil, sources, want)
+		}
+	}
+}
+
+func TestFileURIPath(t *testing.T) {
+	if p, ok := fileURIPath("file:///home/u/project"); !ok || p != filepath.FromSlash("/home/u/project") {
+		t.Errorf("fileURIPath = %q, %v", p, ok)
+	}
+	if _, ok := fileURIPath("https://example.com/x"); ok {
+		t.Error("non-file URI accepted")
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,9 +18,62 @@ const cacheTTL = 15 * time.Minute
 
 // SearchModelsArgs defines the input schema for the search_models tool.
 type SearchModelsArgs struct {
-	Query    string `json:"query,omitempty" jsonschema:"Search query — matches model ID, display name, description, and owner (case-insensitive substring match)"`
+	Query    string `json:"query,omitempty" jsonschema:"Search query — every word must match the model ID, display name, description or owner, allowing small typos. Results are ranked by relevance unless sort_by is set"`
 	OwnedBy  string `json:"owned_by,omitempty" jsonschema:"Filter by owner/provider (e.g. OpenAI, Anthropic, Google, Meta)"`
-	Modality string `json:"modality,omitempty" jsonschema:"Filter by modality substring (e.g. text, image, video)"`
+	Modality string `json:"modality,omitempty" jsonschema:"Filter by modality substring on either side (e.g. text, image, video); prefer input_modality or output_modality"`
+	SortBy   string `json:"sort_by,omitempty" jsonschema:"Sort by id, owner, context_length, prompt_price or completion_price (default: relevance to the query, otherwise catalog order)"`
+	Order    string `json:"order,omitempty" jsonschema:"Sort direction: asc (default) or desc. Models without the sorted value come last either way"`
+	Limit    int    `json:"limit,omitempty" jsonschema:"Maximum models to return (default: 50)"`
+	Offset   int    `json:"offset,omitempty" jsonschema:"Number of matching models to skip; pass next_offset from the previous page"`
+
+	InputModality  []string `json:"input_modality,omitempty" jsonschema:"Only models accepting these inputs (e.g. image, video)"`
+	OutputModality []string `json:"output_modality,omitempty" jsonschema:"Only models producing these outputs (e.g. text, image)"`
+	ModalityMatch  string   `json:"modality_match,omitempty" jsonschema:"any (default): a model needs one of the listed modalities; all: it needs every one"`
+
+	MinContext         int      `json:"min_context,omitempty" jsonschema:"Only models with a context window of at least this many tokens"`
+	MinMaxOutput       int      `json:"min_max_output,omitempty" jsonschema:"Only models that can output at least this many tokens"`
+	MaxPromptPrice     *float64 `json:"max_prompt_price,omitempty" jsonschema:"Maximum USD per million input tokens"`
+	MaxCompletionPrice *float64 `json:"max_completion_price,omitempty" jsonschema:"Maximum USD per million output tokens"`
+	MaxRequestPrice    *float64 `json:"max_request_price,omitempty" jsonschema:"Maximum USD per request"`
+	HasPricing         bool     `json:"has_pricing,omitempty" jsonschema:"Only models that list pricing in the catalog"`
+}
+
+// defaultSearchLimit caps a search_models page when no limit is given, so an
+// unfiltered search does not return the whole catalog.
+const defaultSearchLimit = 50
+
+// ModelPricing is catalog pricing in USD. Token prices are normalised to
+// dollars per million tokens; missing components are omitted.
+type ModelPricing struct {
+	PromptPerMTok     *float64 `json:"prompt_per_mtok,omitempty" jsonschema:"USD per million input tokens"`
+	CompletionPerMTok *float64 `json:"completion_per_mtok,omitempty" jsonschema:"USD per million output tokens"`
+	CacheReadPerMTok  *float64 `json:"cache_read_per_mtok,omitempty" jsonschema:"USD per million cached input tokens read"`
+	CacheWritePerMTok *float64 `json:"cache_write_per_mtok,omitempty" jsonschema:"USD per million input tokens written to the cache"`
+	Request           *float64 `json:"request,omitempty" jsonschema:"USD per request"`
+	Image             *float64 `json:"image,omitempty" jsonschema:"USD per image"`
+}
+
+// ModelInfo describes a catalog model in the search_models result.
+type ModelInfo struct {
+	ID               string        `json:"id"`
+	DisplayName      string        `json:"display_name,omitempty"`
+	Owner            string        `json:"owner,omitempty"`
+	Description      string        `json:"description,omitempty"`
+	InputModalities  []string      `json:"input_modalities,omitempty"`
+	OutputModalities []string      `json:"output_modalities,omitempty"`
+	ContextLength    int           `json:"context_length,omitempty" jsonschema:"Context window in tokens"`
+	MaxOutputTokens  int           `json:"max_output_tokens,omitempty"`
+	Pricing          *ModelPricing `json:"pricing,omitempty"`
+	Score            float64       `json:"score,omitempty" jsonschema:"Relevance to the query, from 0 to 1; 1 is an exact ID or display name match"`
+}
+
+// SearchModelsResult defines the structured output of the search_models tool.
+type SearchModelsResult struct {
+	Total      int         `json:"total" jsonschema:"Number of matching models across all pages"`
+	Offset     int         `json:"offset"`
+	NextOffset int         `json:"next_offset,omitempty" jsonschema:"Offset of the next page, if there is one"`
+	HasMore    bool        `json:"has_more"`
+	Models     []ModelInfo `json:"models"`
 }
 
 // modelCache provides an in-memory cache for the Poe model catalog.
@@ -58,11 +114,11 @@ func (c *modelCache) get(ctx context.Context) ([]models.Model, error) {
 func registerSearchModels(server *mcp.Server) {
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "search_models",
-		Description: "Search and filter the Poe.com model catalog by name, owner, or modality. Returns all models if no filters are specified.",
+		Description: "Search and filter the Poe.com model catalog by name, owner, modality, context size or price. Results can be sorted and are paged (50 per page by default); use next_offset to fetch the next page. The structured result lists each model's modalities, context length and pricing in USD per million tokens.",
 	}, handleSearchModels)
 }
 
-func handleSearchModels(ctx context.Context, req *mcp.CallToolRequest, args SearchModelsArgs) (*mcp.CallToolResult, any, error) {
+func handleSearchModels(ctx context.Context, req *mcp.CallToolRequest, args SearchModelsArgs) (*mcp.CallToolResult, *SearchModelsResult, error) {
 	all, err := cache.get(ctx)
 	if err != nil {
 		return &mcp.CallToolResult{
@@ -73,33 +129,216 @@ func handleSearchModels(ctx context.Context, req *mcp.CallToolRequest, args Sear
 		}, nil, nil
 	}
 
-	matched := filterModels(all, args)
+	if args.Limit == 0 {
+		args.Limit = defaultSearchLimit
+	}
+	page, err := searchModels(all, args)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{&mcp.TextContent{Text: err.Error()}},
+			IsError: true,
+		}, nil, nil
+	}
+	result := &SearchModelsResult{
+		Total:   page.Total,
+		Offset:  page.Offset,
+		HasMore: page.hasMore(),
+		Models:  make([]ModelInfo, len(page.Models)),
+	}
+	if result.HasMore {
+		result.NextOffset = page.Offset + len(page.Models)
+	}
+	for i, m := range page.Models {
+		result.Models[i] = modelInfo(m)
+		if args.Query != "" {
+			result.Models[i].Score = queryScore(m, args.Query)
+		}
+	}
 
-	if len(matched) == 0 {
+	if page.Total == 0 {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				&mcp.TextContent{Text: "No models found matching the given criteria."},
 			},
-		}, nil, nil
+		}, result, nil
 	}
 
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
-			&mcp.TextContent{Text: formatModels(matched)},
+			&mcp.TextContent{Text: formatModelPage(page)},
 		},
-	}, nil, nil
+	}, result, nil
+}
+
+// modelInfo converts a catalog entry to its structured form.
+func modelInfo(m models.Model) ModelInfo {
+	info := ModelInfo{
+		ID:               m.ID,
+		DisplayName:      m.Metadata.DisplayName,
+		Owner:            m.OwnedBy,
+		Description:      m.Description,
+		InputModalities:  inputModalities(m),
+		OutputModalities: outputModalities(m),
+		Pricing:          modelPricing(m.Pricing),
+	}
+	info.ContextLength, info.MaxOutputTokens = contextLimits(m)
+	return info
 }
 
-// filterModels filters the model list by the search criteria in args.
+// modelPricing parses catalog price strings, or returns nil if none are set.
+func modelPricing(p *models.Pricing) *ModelPricing {
+	if p == nil {
+		return nil
+	}
+	price := func(s *string, scale float64) *float64 {
+		v, ok := parsePrice(s)
+		if !ok {
+			return nil
+		}
+		// Round away float artifacts such as 2.9999999999999996.
+		v = math.Round(v*scale*1e9) / 1e9
+		return &v
+	}
+	out := &ModelPricing{
+		PromptPerMTok:     price(p.Prompt, 1e6),
+		CompletionPerMTok: price(p.Completion, 1e6),
+		CacheReadPerMTok:  price(p.InputCacheRead, 1e6),
+		CacheWritePerMTok: price(p.InputCacheWrite, 1e6),
+		Request:           price(p.Request, 1),
+		Image:             price(p.Image, 1),
+	}
+	if *out == (ModelPricing{}) {
+		return nil
+	}
+	return out
+}
+
+// searchPage is one page of sorted search results.
+type searchPage struct {
+	Models []models.Model
+	Total  int // matches across all pages
+	Offset int
+}
+
+func (p searchPage) hasMore() bool {
+	return p.Offset+len(p.Models) < p.Total
+}
+
+// searchModels filters, sorts and paginates the catalog. A zero limit
+// returns every match.
+func searchModels(all []models.Model, args SearchModelsArgs) (searchPage, error) {
+	if args.Limit < 0 || args.Offset < 0 {
+		return searchPage{}, fmt.Errorf("limit and offset must not be negative")
+	}
+	switch strings.ToLower(args.ModalityMatch) {
+	case "", "any", "all":
+	default:
+		return searchPage{}, fmt.Errorf("invalid modality_match %q (want any or all)", args.ModalityMatch)
+	}
+	matched := filterModels(all, args)
+	if err := sortModels(matched, args.SortBy, args.Order); err != nil {
+		return searchPage{}, err
+	}
+	page := searchPage{Total: len(matched), Offset: args.Offset}
+	if args.Offset >= len(matched) {
+		return page, nil
+	}
+	end := len(matched)
+	if args.Limit > 0 {
+		end = min(end, args.Offset+args.Limit)
+	}
+	page.Models = matched[args.Offset:end]
+	return page, nil
+}
+
+// sortKeys extract the value a model is sorted by; ok is false when the
+// catalog does not list it.
+var sortKeys = map[string]func(models.Model) (key any, ok bool){
+	"id":    func(m models.Model) (any, bool) { return strings.ToLower(m.ID), true },
+	"owner": func(m models.Model) (any, bool) { return strings.ToLower(m.OwnedBy), m.OwnedBy != "" },
+	"context_length": func(m models.Model) (any, bool) {
+		n, _ := contextLimits(m)
+		return n, n > 0
+	},
+	"prompt_price": func(m models.Model) (any, bool) {
+		if m.Pricing == nil {
+			return 0.0, false
+		}
+		return parsePrice(m.Pricing.Prompt)
+	},
+	"completion_price": func(m models.Model) (any, bool) {
+		if m.Pricing == nil {
+			return 0.0, false
+		}
+		return parsePrice(m.Pricing.Completion)
+	},
+}
+
+// sortModels sorts ms in place by sortBy ("" keeps the current order) in the
+// given order. Models missing the value come last in either direction, and
+// ties are broken by ID.
+func sortModels(ms []models.Model, sortBy, order string) error {
+	desc := false
+	switch strings.ToLower(order) {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		return fmt.Errorf("invalid order %q (want asc or desc)", order)
+	}
+	if sortBy == "" {
+		return nil
+	}
+	key, ok := sortKeys[strings.ToLower(sortBy)]
+	if !ok {
+		return fmt.Errorf("invalid sort_by %q (want id, owner, context_length, prompt_price or completion_price)", sortBy)
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		ki, oki := key(ms[i])
+		kj, okj := key(ms[j])
+		if oki != okj {
+			return oki
+		}
+		if c := compareKeys(ki, kj); oki && c != 0 {
+			return (c < 0) != desc
+		}
+		return strings.ToLower(ms[i].ID) < strings.ToLower(ms[j].ID)
+	})
+	return nil
+}
+
+func compareKeys(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int:
+		return cmp.Compare(a, b.(int))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	}
+	return 0
+}
+
+// filterModels filters the model list by the search criteria in args. With a
+// query, the best matches come first; ties keep the shorter ID first, then
+// catalog order.
 func filterModels(all []models.Model, args SearchModelsArgs) []models.Model {
-	query := strings.ToLower(args.Query)
+	query := strings.TrimSpace(args.Query)
 	ownedBy := strings.ToLower(args.OwnedBy)
 	modality := strings.ToLower(args.Modality)
+	wantIn := splitModalities(args.InputModality)
+	wantOut := splitModalities(args.OutputModality)
+	matchAll := strings.EqualFold(args.ModalityMatch, "all")
 
 	var result []models.Model
+	scores := map[string]float64{}
 	for _, m := range all {
-		if query != "" && !matchesQuery(m, query) {
-			continue
+		if query != "" {
+			score := queryScore(m, query)
+			if score == 0 {
+				continue
+			}
+			scores[m.ID] = score
 		}
 		if ownedBy != "" && strings.ToLower(m.OwnedBy) != ownedBy {
 			continue
@@ -107,22 +346,63 @@ func filterModels(all []models.Model, args SearchModelsArgs) []models.Model {
 		if modality != "" && !strings.Contains(strings.ToLower(m.Architecture.Modality), modality) {
 			continue
 		}
+		if len(wantIn) > 0 && !hasModalities(inputModalities(m), wantIn, matchAll) {
+			continue
+		}
+		if len(wantOut) > 0 && !hasModalities(outputModalities(m), wantOut, matchAll) {
+			continue
+		}
+		if !withinLimits(m, args) {
+			continue
+		}
 		result = append(result, m)
 	}
+	if query != "" {
+		sort.SliceStable(result, func(i, j int) bool {
+			si, sj := scores[result[i].ID], scores[result[j].ID]
+			if si != sj {
+				return si > sj
+			}
+			return len(result[i].ID) < len(result[j].ID)
+		})
+	}
 	return result
 }
 
-// matchesQuery checks if all words in the query appear somewhere across the model's searchable fields.
-func matchesQuery(m models.Model, query string) bool {
-	combined := strings.ToLower(strings.Join([]string{
-		m.ID, m.Metadata.DisplayName, m.Description, m.OwnedBy,
-	}, " "))
-	for _, word := range strings.Fields(query) {
-		if !strings.Contains(combined, word) {
-			return false
+// hasModalities reports whether have includes any of want, or all of them
+// when all is set.
+func hasModalities(have, want []string, all bool) bool {
+	for _, w := range want {
+		if containsString(have, w) != all {
+			return !all
 		}
 	}
-	return true
+	return all
+}
+
+// withinLimits applies the numeric filters in args. A model the catalog has
+// no value for never satisfies a filter on that value.
+func withinLimits(m models.Model, args SearchModelsArgs) bool {
+	contextLength, maxOutput := contextLimits(m)
+	if args.MinContext > 0 && contextLength < args.MinContext {
+		return false
+	}
+	if args.MinMaxOutput > 0 && maxOutput < args.MinMaxOutput {
+		return false
+	}
+	pricing := modelPricing(m.Pricing)
+	if args.HasPricing && pricing == nil {
+		return false
+	}
+	if pricing == nil {
+		pricing = &ModelPricing{}
+	}
+	atMost := func(v, limit *float64) bool {
+		return limit == nil || (v != nil && *v <= *limit)
+	}
+	return atMost(pricing.PromptPerMTok, args.MaxPromptPrice) &&
+		atMost(pricing.CompletionPerMTok, args.MaxCompletionPrice) &&
+		atMost(pricing.Request, args.MaxRequestPrice)
 }
 
 // formatModels formats a slice of models as readable text.
@@ -138,6 +418,30 @@ func formatModels(matched []models.Model) string {
 	return sb.String()
 }
 
+// formatModelPage formats one page of results, noting where the next page
+// starts.
+func formatModelPage(p searchPage) string {
+	if p.Offset == 0 && !p.hasMore() {
+		return formatModels(p.Models)
+	}
+	var sb strings.Builder
+	if len(p.Models) == 0 {
+		fmt.Fprintf(&sb, "Found %d model(s); offset %d is past the last one.\n", p.Total, p.Offset)
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "Found %d model(s), showing %d-%d:\n\n", p.Total, p.Offset+1, p.Offset+len(p.Models))
+	for i, m := range p.Models {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(formatModel(m))
+	}
+	if p.hasMore() {
+		fmt.Fprintf(&sb, "\nMore results: next offset %d.\n", p.Offset+len(p.Models))
+	}
+	return sb.String()
+}
+
 // formatModel formats a single model as a readable text block.
 func formatModel(m models.Model) string {
 	var sb strings.Builder
@@ -146,8 +450,11 @@ func formatModel(m models.Model) string {
 		fmt.Fprintf(&sb, "Display Name: %s\n", m.Metadata.DisplayName)
 	}
 	fmt.Fprintf(&sb, "Owner: %s\n", m.OwnedBy)
-	if m.Architecture.Modality != "" {
-		fmt.Fprintf(&sb, "Modality: %s\n", m.Architecture.Modality)
+	if in := inputModalities(m); len(in) > 0 {
+		fmt.Fprintf(&sb, "Input: %s\n", strings.Join(in, ", "))
+	}
+	if out := outputModalities(m); len(out) > 0 {
+		fmt.Fprintf(&sb, "Output: %s\n", strings.Join(out, ", "))
 	}
 	if m.Description != "" {
 		fmt.Fprintf(&sb, "Description: %s\n", m.Description)
@@ -158,21 +465,45 @@ func formatModel(m models.Model) string {
 			fmt.Fprintf(&sb, "Max Output: %d tokens\n", *m.ContextWindow.MaxOutputTokens)
 		}
 	}
-	if m.Pricing != nil {
-		sb.WriteString("Pricing:")
-		if m.Pricing.Prompt != nil {
-			fmt.Fprintf(&sb, " prompt=%s", *m.Pricing.Prompt)
-		}
-		if m.Pricing.Completion != nil {
-			fmt.Fprintf(&sb, " completion=%s", *m.Pricing.Completion)
-		}
-		if m.Pricing.Request != nil {
-			fmt.Fprintf(&sb, " request=%s", *m.Pricing.Request)
+	writePricing(&sb, modelPricing(m.Pricing))
+	return sb.String()
+}
+
+// writePricing writes one line per price in p, with its unit, as in the
+// structured result. A nil p writes nothing.
+func writePricing(sb *strings.Builder, p *ModelPricing) {
+	if p == nil {
+		return
+	}
+	for _, price := range []struct {
+		label string
+		usd   *float64
+		unit  string
+	}{
+		{"Prompt", p.PromptPerMTok, "per million tokens"},
+		{"Completion", p.CompletionPerMTok, "per million tokens"},
+		{"Cache Read", p.CacheReadPerMTok, "per million tokens"},
+		{"Cache Write", p.CacheWritePerMTok, "per million tokens"},
+		{"Request", p.Request, "per request"},
+		{"Image", p.Image, "per image"},
+	} {
+		if price.usd != nil {
+			fmt.Fprintf(sb, "%s: $%g %s\n", price.label, *price.usd, price.unit)
 		}
-		if m.Pricing.Image != nil {
-			fmt.Fprintf(&sb, " image=%s", *m.Pricing.Image)
+	}
+}
+
+// contextLimits returns the context length and maximum output tokens of m,
+// or zero when the catalog does not list them.
+func contextLimits(m models.Model) (contextLength, maxOutput int) {
+	if m.ContextWindow != nil && m.ContextWindow.ContextLength > 0 {
+		if m.ContextWindow.MaxOutputTokens != nil {
+			maxOutput = *m.ContextWindow.MaxOutputTokens
 		}
-		sb.WriteString("\n")
+		return m.ContextWindow.ContextLength, maxOutput
 	}
-	return sb.String()
+	if m.ContextLength != nil {
+		return *m.ContextLength, 0
+	}
+	return 0, 0
 }
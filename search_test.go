@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/n0madic/go-poe/models"
 )
 
@@ -176,11 +179,12 @@ func TestFormatModel(t *testing.T) {
 		"## gpt-4o",
 		"Display Name: GPT-4o",
 		"Owner: OpenAI",
-		"Modality: text,image->text",
+		"Input: text, image\n",
+		"Output: text\n",
 		"Context Length: 128000 tokens",
 		"Max Output: 16384 tokens",
-		"prompt=0.000005",
-		"completion=0.000015",
+		"Prompt: $5 per million tokens\n",
+		"Completion: $15 per million tokens\n",
 	}
 
 	for _, s := range expected {
@@ -207,3 +211,215 @@ func TestFormatModelMinimal(t *testing.T) {
 		t.Error("should not show display name when same as empty")
 	}
 }
+
+func TestModelInfo(t *testing.T) {
+	all := sampleModels()
+
+	info := modelInfo(all[0])
+	if info.ID != "gpt-4o" || info.DisplayName != "GPT-4o" || info.Owner != "OpenAI" {
+		t.Errorf("identity = %+v", info)
+	}
+	if strings.Join(info.InputModalities, ",") != "text,image" || strings.Join(info.OutputModalities, ",") != "text" {
+		t.Errorf("modalities = %v -> %v", info.InputModalities, info.OutputModalities)
+	}
+	if info.ContextLength != 128000 || info.MaxOutputTokens != 16384 {
+		t.Errorf("limits = %d/%d", info.ContextLength, info.MaxOutputTokens)
+	}
+	p := info.Pricing
+	if p == nil || p.PromptPerMTok == nil || *p.PromptPerMTok != 5 || *p.CompletionPerMTok != 15 || p.Image != nil {
+		t.Errorf("pricing = %+v", p)
+	}
+
+	img := modelInfo(all[2])
+	if img.Pricing == nil || img.Pricing.Image == nil || *img.Pricing.Image != 0.04 || img.Pricing.PromptPerMTok != nil {
+		t.Errorf("image pricing = %+v", img.Pricing)
+	}
+	if img.ContextLength != 0 || strings.Join(img.OutputModalities, ",") != "image" {
+		t.Errorf("dall-e-3 = %+v", img)
+	}
+
+	if modelPricing(&models.Pricing{}) != nil {
+		t.Error("empty pricing should be omitted")
+	}
+}
+
+func TestHandleSearchModelsStructured(t *testing.T) {
+	orig := cache
+	defer func() { cache = orig }()
+	cache = &modelCache{models: sampleModels(), fetchedAt: time.Now()}
+
+	res, out, err := handleSearchModels(context.Background(), nil, SearchModelsArgs{OwnedBy: "OpenAI"})
+	if err != nil || res.IsError {
+		t.Fatalf("handleSearchModels: %v %+v", err, res)
+	}
+	if out.Total != 2 || len(out.Models) != 2 || out.Models[0].ID != "gpt-4o" || out.Models[1].ID != "dall-e-3" {
+		t.Errorf("result = %+v", out)
+	}
+	if text := res.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, "Found 2 model(s)") {
+		t.Errorf("text = %q, want the readable listing alongside", text)
+	}
+
+	_, out, _ = handleSearchModels(context.Background(), nil, SearchModelsArgs{Query: "nothing-matches"})
+	if out.Total != 0 || out.Models == nil {
+		t.Errorf("empty result = %+v, want total 0 and an empty list", out)
+	}
+}
+
+func modelIDs(ms []models.Model) string {
+	ids := make([]string, len(ms))
+	for i, m := range ms {
+		ids[i] = m.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSortModels(t *testing.T) {
+	tests := []struct {
+		sortBy, order string
+		want          string
+	}{
+		{"", "", "gpt-4o,claude-4.5-sonnet,dall-e-3,gemini-2.5-pro"},
+		{"id", "asc", "claude-4.5-sonnet,dall-e-3,gemini-2.5-pro,gpt-4o"},
+		{"ID", "desc", "gpt-4o,gemini-2.5-pro,dall-e-3,claude-4.5-sonnet"},
+		{"owner", "", "claude-4.5-sonnet,gemini-2.5-pro,dall-e-3,gpt-4o"},
+		// dall-e-3 has no context window or token prices, so it comes last.
+		{"context_length", "desc", "gemini-2.5-pro,claude-4.5-sonnet,gpt-4o,dall-e-3"},
+		{"context_length", "asc", "gpt-4o,claude-4.5-sonnet,gemini-2.5-pro,dall-e-3"},
+		{"prompt_price", "asc", "gemini-2.5-pro,claude-4.5-sonnet,gpt-4o,dall-e-3"},
+		{"completion_price", "desc", "claude-4.5-sonnet,gpt-4o,gemini-2.5-pro,dall-e-3"},
+	}
+	for _, tt := range tests {
+		ms := sampleModels()
+		if err := sortModels(ms, tt.sortBy, tt.order); err != nil {
+			t.Fatalf("sortModels(%s, %s): %v", tt.sortBy, tt.order, err)
+		}
+		if got := modelIDs(ms); got != tt.want {
+			t.Errorf("sortModels(%s, %s) = %s, want %s", tt.sortBy, tt.order, got, tt.want)
+		}
+	}
+
+	if err := sortModels(sampleModels(), "price", ""); err == nil {
+		t.Error("expected error for unknown sort field")
+	}
+	if err := sortModels(sampleModels(), "id", "up"); err == nil {
+		t.Error("expected error for unknown order")
+	}
+}
+
+func TestSearchModelsPagination(t *testing.T) {
+	all := sampleModels()
+	page, err := searchModels(all, SearchModelsArgs{SortBy: "id", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 4 || modelIDs(page.Models) != "claude-4.5-sonnet,dall-e-3,gemini-2.5-pro" || !page.hasMore() {
+		t.Errorf("first page = %+v", page)
+	}
+	if text := formatModelPage(page); !strings.Contains(text, "showing 1-3") || !strings.Contains(text, "next offset 3") {
+		t.Errorf("first page text = %q", text)
+	}
+
+	page, _ = searchModels(all, SearchModelsArgs{SortBy: "id", Limit: 3, Offset: 3})
+	if modelIDs(page.Models) != "gpt-4o" || page.hasMore() {
+		t.Errorf("second page = %+v", page)
+	}
+
+	page, _ = searchModels(all, SearchModelsArgs{Offset: 10})
+	if page.Total != 4 || len(page.Models) != 0 {
+		t.Errorf("past the end = %+v", page)
+	}
+
+	if _, err := searchModels(all, SearchModelsArgs{Limit: -1}); err == nil {
+		t.Error("expected error for negative limit")
+	}
+}
+
+func TestHandleSearchModelsPaging(t *testing.T) {
+	orig := cache
+	defer func() { cache = orig }()
+	cache = &modelCache{models: sampleModels(), fetchedAt: time.Now()}
+
+	_, out, _ := handleSearchModels(context.Background(), nil, SearchModelsArgs{SortBy: "context_length", Order: "desc", Limit: 2})
+	if out.Total != 4 || !out.HasMore || out.NextOffset != 2 || len(out.Models) != 2 || out.Models[0].ID != "gemini-2.5-pro" {
+		t.Errorf("result = %+v", out)
+	}
+
+	res, out, _ := handleSearchModels(context.Background(), nil, SearchModelsArgs{SortBy: "size"})
+	if !res.IsError || out != nil {
+		t.Errorf("invalid sort_by should fail, got %+v", res)
+	}
+}
+
+func TestFilterModelsNumeric(t *testing.T) {
+	price := func(v float64) *float64 { return &v }
+	free := models.Model{ID: "free-bot", OwnedBy: "Poe"}
+	all := append(sampleModels(), free)
+	tests := []struct {
+		name string
+		args SearchModelsArgs
+		want string
+	}{
+		{"min context", SearchModelsArgs{MinContext: 200000}, "claude-4.5-sonnet,gemini-2.5-pro"},
+		{"min max output", SearchModelsArgs{MinMaxOutput: 16000}, "gpt-4o,gemini-2.5-pro"},
+		{"max prompt price", SearchModelsArgs{MaxPromptPrice: price(3)}, "claude-4.5-sonnet,gemini-2.5-pro"},
+		{"max completion price", SearchModelsArgs{MaxCompletionPrice: price(10)}, "gemini-2.5-pro"},
+		{"zero price", SearchModelsArgs{MaxPromptPrice: price(0)}, ""},
+		{"has pricing", SearchModelsArgs{HasPricing: true}, "gpt-4o,claude-4.5-sonnet,dall-e-3,gemini-2.5-pro"},
+		{"combined", SearchModelsArgs{Modality: "image", MinContext: 200000, MaxPromptPrice: price(3)}, "claude-4.5-sonnet,gemini-2.5-pro"},
+	}
+	for _, tt := range tests {
+		if got := modelIDs(filterModels(all, tt.args)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Models without a request price never satisfy max_request_price.
+	if got := filterModels(all, SearchModelsArgs{MaxRequestPrice: price(1)}); len(got) != 0 {
+		t.Errorf("max request price: got %s", modelIDs(got))
+	}
+}
+
+func TestParseModality(t *testing.T) {
+	tests := []struct {
+		modality, in, out string
+	}{
+		{"text,image,video->text", "text,image,video", "text"},
+		{"text->image", "text", "image"},
+		{" Text , IMAGE -> text,image ", "text,image", "text,image"},
+		{"text", "text", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		in, out := parseModality(tt.modality)
+		if strings.Join(in, ",") != tt.in || strings.Join(out, ",") != tt.out {
+			t.Errorf("parseModality(%q) = %v, %v; want %s, %s", tt.modality, in, out, tt.in, tt.out)
+		}
+	}
+}
+
+func TestFilterModelsInputOutputModality(t *testing.T) {
+	all := sampleModels()
+	tests := []struct {
+		name string
+		args SearchModelsArgs
+		want string
+	}{
+		// The substring filter matches image generators too.
+		{"substring", SearchModelsArgs{Modality: "image"}, "gpt-4o,claude-4.5-sonnet,dall-e-3,gemini-2.5-pro"},
+		{"image input", SearchModelsArgs{InputModality: []string{"image"}}, "gpt-4o,claude-4.5-sonnet,gemini-2.5-pro"},
+		{"image output", SearchModelsArgs{OutputModality: []string{"image"}}, "dall-e-3"},
+		{"any input", SearchModelsArgs{InputModality: []string{"video", "audio"}}, "gemini-2.5-pro"},
+		{"all inputs", SearchModelsArgs{InputModality: []string{"image,video"}, ModalityMatch: "all"}, "gemini-2.5-pro"},
+		{"all missing", SearchModelsArgs{InputModality: []string{"video", "audio"}, ModalityMatch: "ALL"}, ""},
+		{"both sides", SearchModelsArgs{InputModality: []string{"Image"}, OutputModality: []string{"text"}}, "gpt-4o,claude-4.5-sonnet,gemini-2.5-pro"},
+	}
+	for _, tt := range tests {
+		if got := modelIDs(filterModels(all, tt.args)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := searchModels(all, SearchModelsArgs{InputModality: []string{"image"}, ModalityMatch: "some"}); err == nil {
+		t.Error("expected error for invalid modality_match")
+	}
+}
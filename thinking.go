@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// thinkingMode controls how reasoning output is rendered in CLI mode.
+type thinkingMode string
+
+const (
+	thinkingShow thinkingMode = "show"
+	thinkingHide thinkingMode = "hide"
+	thinkingDim  thinkingMode = "dim"
+)
+
+// parseThinkingMode validates a --thinking flag value.
+func parseThinkingMode(s string) (thinkingMode, error) {
+	switch m := thinkingMode(strings.ToLower(s)); m {
+	case thinkingShow, thinkingHide, thinkingDim:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid thinking mode %q (want show, hide or dim)", s)
+	}
+}
+
+// thinkingTags lists the open/close tag pairs bots use to wrap reasoning.
+var thinkingTags = [][2]string{
+	{"<think>", "</think>"},
+	{"<thinking>", "</thinking>"},
+	{"<reasoning>", "</reasoning>"},
+}
+
+// thinkingHeader matches the "Thinking..." line that Poe reasoning bots emit
+// before a blockquoted reasoning section, e.g. "*Thinking...*" or
+// "Thinking... (5s elapsed)". The header must stand alone on its line, so an
+// answer that merely starts with "Thinking..." is left alone.
+var thinkingHeader = regexp.MustCompile(`^([*_]{0,2})Thinking\.\.\.(?: *\([^)\n]*\))?([*_]{0,2})[ \t\r]*$`)
+
+// thinkingHeaderStart matches the start of a line that may be a
+// thinkingHeader; the rest of the line decides.
+var thinkingHeaderStart = regexp.MustCompile(`^[*_]{0,2}Thinking\.\.\.`)
+
+// thinkingHeaderPrefixes are the shortest strings that still could grow into a
+// thinkingHeader match; used to decide whether to wait for more input.
+var thinkingHeaderPrefixes = []string{"Thinking...", "*Thinking...", "**Thinking...", "_Thinking...", "__Thinking..."}
+
+type parserState int
+
+const (
+	stateStart parserState = iota
+	stateTag
+	stateQuote
+	stateAnswer
+)
+
+// segment is a piece of bot output classified as reasoning or answer.
+type segment struct {
+	Text      string
+	Reasoning bool
+}
+
+// thinkingParser incrementally splits streamed bot output into reasoning and
+// answer segments. It recognises <think>-style tags and the "Thinking..."
+// header followed by a blockquote. Output that starts with neither is passed
+// through as answer text unchanged.
+type thinkingParser struct {
+	state    parserState
+	buf      string
+	closeTag string
+}
+
+// Write feeds a chunk of streamed text and returns any segments that can
+// already be classified.
+func (p *thinkingParser) Write(chunk string) []segment {
+	p.buf += chunk
+	return p.drain(false)
+}
+
+// Flush classifies any buffered text at the end of the stream.
+func (p *thinkingParser) Flush() []segment {
+	return p.drain(true)
+}
+
+func (p *thinkingParser) drain(final bool) []segment {
+	var out []segment
+	for {
+		switch p.state {
+		case stateStart:
+			trimmed := strings.TrimLeft(p.buf, " \t\r\n")
+			if trimmed == "" {
+				if final {
+					p.state = stateAnswer
+					continue
+				}
+				return out
+			}
+			if openTag, closeTag, ok := matchOpenTag(trimmed); ok {
+				p.buf = trimmed[len(openTag):]
+				p.closeTag = closeTag
+				p.state = stateTag
+				continue
+			}
+			if thinkingHeaderStart.MatchString(trimmed) {
+				line, rest, complete := strings.Cut(trimmed, "\n")
+				if !complete && !final {
+					return out
+				}
+				if m := thinkingHeader.FindStringSubmatch(line); m != nil && m[1] == m[2] {
+					// The header line itself carries no reasoning content.
+					p.buf = rest
+					p.state = stateQuote
+					continue
+				}
+				p.state = stateAnswer
+				continue
+			}
+			if !final && couldBeMarker(trimmed) {
+				return out
+			}
+			p.state = stateAnswer
+
+		case stateTag:
+			if i := indexFold(p.buf, p.closeTag); i >= 0 {
+				out = appendSegment(out, p.buf[:i], true)
+				p.buf = strings.TrimLeft(p.buf[i+len(p.closeTag):], " \t\r\n")
+				p.state = stateAnswer
+				continue
+			}
+			// Hold back a possible partial close tag at the end of the buffer.
+			keep := 0
+			if !final {
+				keep = partialSuffix(p.buf, p.closeTag)
+			}
+			out = appendSegment(out, p.buf[:len(p.buf)-keep], true)
+			p.buf = p.buf[len(p.buf)-keep:]
+			return out
+
+		case stateQuote:
+			line, rest, complete := strings.Cut(p.buf, "\n")
+			if !complete && !final {
+				return out
+			}
+			if !complete && line == "" {
+				return out
+			}
+			trimmed := strings.TrimSpace(line)
+			if trimmed != "" && !strings.HasPrefix(trimmed, ">") {
+				p.state = stateAnswer
+				continue
+			}
+			text := strings.TrimPrefix(strings.TrimPrefix(trimmed, ">"), " ")
+			if complete {
+				text += "\n"
+			}
+			out = appendSegment(out, text, true)
+			p.buf = rest
+
+		case stateAnswer:
+			out = appendSegment(out, p.buf, false)
+			p.buf = ""
+			return out
+		}
+	}
+}
+
+// matchOpenTag reports whether s starts with a known reasoning open tag,
+// ignoring case.
+func matchOpenTag(s string) (openTag, closeTag string, ok bool) {
+	for _, t := range thinkingTags {
+		if hasPrefixFold(s, t[0]) {
+			return t[0], t[1], true
+		}
+	}
+	return "", "", false
+}
+
+// hasPrefixFold is strings.HasPrefix ignoring ASCII case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// indexFold returns the index of the first instance of the ASCII string sub
+// in s, ignoring case, or -1.
+func indexFold(s, sub string) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// couldBeMarker reports whether s is a prefix of a reasoning marker, meaning
+// more input is needed before the output can be classified.
+func couldBeMarker(s string) bool {
+	for _, t := range thinkingTags {
+		if hasPrefixFold(t[0], s) {
+			return true
+		}
+	}
+	for _, h := range thinkingHeaderPrefixes {
+		if strings.HasPrefix(h, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a proper
+// prefix of tag, ignoring case.
+func partialSuffix(s, tag string) int {
+	for n := min(len(tag)-1, len(s)); n > 0; n-- {
+		if strings.EqualFold(s[len(s)-n:], tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// appendSegment appends text to out, merging with the previous segment when
+// both have the same classification.
+func appendSegment(out []segment, text string, reasoning bool) []segment {
+	if text == "" {
+		return out
+	}
+	if n := len(out); n > 0 && out[n-1].Reasoning == reasoning {
+		out[n-1].Text += text
+		return out
+	}
+	return append(out, segment{Text: text, Reasoning: reasoning})
+}
+
+// splitThinking separates a complete bot response into its reasoning section
+// and the final answer.
+func splitThinking(text string) (reasoning, answer string) {
+	var p thinkingParser
+	var r, a strings.Builder
+	for _, seg := range append(p.Write(text), p.Flush()...) {
+		if seg.Reasoning {
+			r.WriteString(seg.Text)
+		} else {
+			a.WriteString(seg.Text)
+		}
+	}
+	return strings.TrimSpace(r.String()), strings.TrimSpace(a.String())
+}
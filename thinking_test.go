@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSplitThinking(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantReasoning string
+		wantAnswer    string
+	}{
+		{
+			name:       "plain answer",
+			input:      "Go is a programming language.",
+			wantAnswer: "Go is a programming language.",
+		},
+		{
+			name:          "think tags",
+			input:         "<think>\nLet me consider.\n</think>\n\nThe answer is 42.",
+			wantReasoning: "Let me consider.",
+			wantAnswer:    "The answer is 42.",
+		},
+		{
+			name:          "thinking tags with leading whitespace",
+			input:         "\n  <thinking>step one</thinking>Done.",
+			wantReasoning: "step one",
+			wantAnswer:    "Done.",
+		},
+		{
+			name:          "poe blockquote",
+			input:         "*Thinking...*\n\n> The user asks about Go.\n> It is a language.\n\nGo is a language.\n> not reasoning",
+			wantReasoning: "The user asks about Go.\nIt is a language.",
+			wantAnswer:    "Go is a language.\n> not reasoning",
+		},
+		{
+			name:          "header with elapsed time",
+			input:         "Thinking... (3s elapsed)\n\n>hmm\n>\n> ok\n\nAnswer",
+			wantReasoning: "hmm\n\nok",
+			wantAnswer:    "Answer",
+		},
+		{
+			name:          "unterminated think tag",
+			input:         "<think>still going",
+			wantReasoning: "still going",
+		},
+		{
+			name:       "answer starting with Thinking",
+			input:      "Thinking... is what I did before answering.\nThe answer is 42.",
+			wantAnswer: "Thinking... is what I did before answering.\nThe answer is 42.",
+		},
+		{
+			name:       "unbalanced header emphasis",
+			input:      "*Thinking...\n> quoted",
+			wantAnswer: "*Thinking...\n> quoted",
+		},
+		{
+			name:          "upper-case tags",
+			input:         "<THINK>hmm</Think>Done.",
+			wantReasoning: "hmm",
+			wantAnswer:    "Done.",
+		},
+		{
+			name:       "tag not at start",
+			input:      "Use <think> tags like this.",
+			wantAnswer: "Use <think> tags like this.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasoning, answer := splitThinking(tt.input)
+			if reasoning != tt.wantReasoning {
+				t.Errorf("reasoning = %q, want %q", reasoning, tt.wantReasoning)
+			}
+			if answer != tt.wantAnswer {
+				t.Errorf("answer = %q, want %q", answer, tt.wantAnswer)
+			}
+		})
+	}
+}
+
+func TestThinkingParserStreaming(t *testing.T) {
+	inputs := []string{
+		"<think>\nLet me consider.\n</think>\n\nThe answer is 42.",
+		"*Thinking...*\n\n> The user asks.\n\nGo is a language.",
+		"<Thinking>step</THINKING>Done.",
+		"Thinking... out loud is fine.\nDone.",
+	}
+
+	for _, input := range inputs {
+		wantReasoning, wantAnswer := splitThinking(input)
+
+		// Feed one byte at a time to exercise partial markers.
+		var p thinkingParser
+		var segs []segment
+		for i := 0; i < len(input); i++ {
+			segs = append(segs, p.Write(input[i:i+1])...)
+		}
+		segs = append(segs, p.Flush()...)
+
+		var r, a strings.Builder
+		for _, seg := range segs {
+			if seg.Reasoning {
+				r.WriteString(seg.Text)
+			} else {
+				a.WriteString(seg.Text)
+			}
+		}
+		if got := strings.TrimSpace(r.String()); got != wantReasoning {
+			t.Errorf("streamed reasoning = %q, want %q", got, wantReasoning)
+		}
+		if got := strings.TrimSpace(a.String()); got != wantAnswer {
+			t.Errorf("streamed answer = %q, want %q", got, wantAnswer)
+		}
+	}
+}
+
+func TestParseThinkingMode(t *testing.T) {
+	for _, s := range []string{"show", "hide", "dim", "HIDE"} {
+		if _, err := parseThinkingMode(s); err != nil {
+			t.Errorf("parseThinkingMode(%q) unexpected error: %v", s, err)
+		}
+	}
+	if _, err := parseThinkingMode("loud"); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+func TestThinkingPrinter(t *testing.T) {
+	input := "<think>reasoning</think>answer"
+
+	tests := []struct {
+		mode thinkingMode
+		want string
+	}{
+		{thinkingShow, input},
+		{thinkingHide, "answer"},
+		{thinkingDim, "\x1b[2mreasoning\x1b[0m\nanswer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			var buf bytes.Buffer
+			p := newThinkingPrinter(&buf, tt.mode)
+			p.Write(input[:10])
+			p.Write(input[10:])
+			p.Flush()
+			if buf.String() != tt.want {
+				t.Errorf("output = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestThinkingPrinterReplace(t *testing.T) {
+	var buf bytes.Buffer
+	p := newThinkingPrinter(&buf, thinkingHide)
+	p.Write("<think>draft</think>First try")
+	p.Replace("<think>again</think>Second try")
+	p.Flush()
+	if want := "First try\nSecond try"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+
+	// Nothing printed yet: the replacement simply starts over.
+	buf.Reset()
+	p = newThinkingPrinter(&buf, thinkingHide)
+	p.Write("<think>partial")
+	p.Replace("Answer")
+	p.Flush()
+	if buf.String() != "Answer" {
+		t.Errorf("output = %q, want %q", buf.String(), "Answer")
+	}
+}
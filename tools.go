@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/n0madic/go-poe/client"
+	"github.com/n0madic/go-poe/types"
+)
+
+const (
+	defaultMaxToolIterations = 5
+	maxReadFileBytes         = 256 * 1024
+)
+
+// errToolLimit is returned when a bot keeps calling tools past the iteration cap.
+var errToolLimit = errors.New("tool iteration limit reached")
+
+// botTool is a server-side tool that can be offered to tool-capable bots.
+type botTool struct {
+	Definition types.ToolDefinition
+	Execute    func(ctx context.Context, sb *sandbox, args string) (string, error)
+}
+
+// botTools holds every tool a bot can be offered, keyed by name.
+var botTools = map[string]botTool{
+	"search_models": {
+		Definition: types.ToolDefinition{
+			Type: "function",
+			Function: types.FunctionDefinition{
+				Name:        "search_models",
+				Description: "Search the Poe.com model catalog by name, owner, or modality. Results are ranked by relevance to the query and paged",
+				Parameters: types.ParametersDefinition{
+					Type: "object",
+					Properties: map[string]any{
+						"query":    map[string]any{"type": "string", "description": "Words to match against model ID, name, description, and owner"},
+						"owned_by": map[string]any{"type": "string", "description": "Filter by owner/provider (e.g. OpenAI, Anthropic)"},
+						"modality": map[string]any{"type": "string", "description": "Filter by modality substring (e.g. text, image)"},
+						"sort_by":  map[string]any{"type": "string", "enum": []string{"id", "owner", "context_length", "prompt_price", "completion_price"}, "description": "Sort key (default: relevance to the query, otherwise catalog order)"},
+						"order":    map[string]any{"type": "string", "enum": []string{"asc", "desc"}, "description": "Sort direction (default: asc)"},
+						"limit":    map[string]any{"type": "integer", "description": fmt.Sprintf("Maximum models to return (default: %d)", defaultSearchLimit)},
+						"offset":   map[string]any{"type": "integer", "description": "Number of matching models to skip, to fetch the next page"},
+					},
+				},
+			},
+		},
+		Execute: execSearchModels,
+	},
+	"read_file": {
+		Definition: types.ToolDefinition{
+			Type: "function",
+			Function: types.FunctionDefinition{
+				Name:        "read_file",
+				Description: "Read a UTF-8 text file from the directories the server may access",
+				Parameters: types.ParametersDefinition{
+					Type: "object",
+					Properties: map[string]any{
+						"path": map[string]any{"type": "string", "description": "Absolute file path, or relative to the first allowed directory (the working directory by default)"},
+					},
+					Required: []string{"path"},
+				},
+			},
+		},
+		Execute: execReadFile,
+	},
+}
+
+// toolNames returns the names of all available bot tools, sorted.
+func toolNames() []string {
+	names := make([]string, 0, len(botTools))
+	for name := range botTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ToolCallRecord is one executed tool call in a tool loop transcript.
+type ToolCallRecord struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+// ToolStep records one round trip of the tool loop: the text the bot produced
+// and the tool calls it requested.
+type ToolStep struct {
+	Iteration int              `json:"iteration"`
+	Text      string           `json:"text,omitempty"`
+	Calls     []ToolCallRecord `json:"calls"`
+}
+
+// runToolLoop queries bot with the named tools enabled, executes every tool
+// call the bot requests, and feeds the results back until the bot answers
+// without calling a tool or maxIter round trips have been made. Tools that
+// read local files are confined to sb.
+func runToolLoop(ctx context.Context, req *types.QueryRequest, bot, key string, names []string, maxIter int, sb *sandbox, meter *usageMeter) (*client.BotResponse, []ToolStep, error) {
+	defs := make([]types.ToolDefinition, 0, len(names))
+	for _, name := range names {
+		tool, ok := botTools[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown tool %q (available: %s)", name, strings.Join(toolNames(), ", "))
+		}
+		defs = append(defs, tool.Definition)
+	}
+	if maxIter <= 0 {
+		maxIter = defaultMaxToolIterations
+	}
+
+	var steps []ToolStep
+	for i := 1; i <= maxIter; i++ {
+		resp, err := client.GetFinalResponse(ctx, req, bot, key, &client.StreamRequestOptions{Tools: defs})
+		meter.observe(req, resp)
+		if err != nil {
+			return nil, steps, err
+		}
+		if len(resp.ToolCalls) == 0 {
+			return resp, steps, nil
+		}
+
+		// Aggregated tool calls come from a map; keep the transcript stable.
+		sort.Slice(resp.ToolCalls, func(a, b int) bool { return resp.ToolCalls[a].ID < resp.ToolCalls[b].ID })
+
+		step := ToolStep{Iteration: i, Text: resp.Text}
+		for _, call := range resp.ToolCalls {
+			rec := executeToolCall(ctx, call, names, sb)
+			step.Calls = append(step.Calls, rec)
+			req.ToolCalls = append(req.ToolCalls, call)
+			req.ToolResults = append(req.ToolResults, types.ToolResultDefinition{
+				Role:       "tool",
+				Name:       call.Function.Name,
+				ToolCallID: call.ID,
+				Content:    rec.Result,
+			})
+		}
+		steps = append(steps, step)
+	}
+	return nil, steps, fmt.Errorf("bot %q still requesting tools after %d iterations: %w", bot, maxIter, errToolLimit)
+}
+
+// executeToolCall runs a single tool call. Errors are reported back to the bot
+// as the tool result rather than aborting the loop.
+func executeToolCall(ctx context.Context, call types.ToolCallDefinition, enabled []string, sb *sandbox) ToolCallRecord {
+	rec := ToolCallRecord{Name: call.Function.Name, Arguments: call.Function.Arguments}
+	tool, ok := botTools[call.Function.Name]
+	if !ok || !containsString(enabled, call.Function.Name) {
+		rec.Result = fmt.Sprintf("error: tool %q is not available", call.Function.Name)
+		rec.IsError = true
+		return rec
+	}
+	result, err := tool.Execute(ctx, sb, call.Function.Arguments)
+	if err != nil {
+		rec.Result = "error: " + err.Error()
+		rec.IsError = true
+		return rec
+	}
+	rec.Result = result
+	return rec
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTranscript renders the tool loop steps as readable text.
+func formatTranscript(steps []ToolStep) string {
+	var sb strings.Builder
+	for _, step := range steps {
+		fmt.Fprintf(&sb, "### Iteration %d\n", step.Iteration)
+		if text := strings.TrimSpace(step.Text); text != "" {
+			fmt.Fprintf(&sb, "Bot: %s\n", text)
+		}
+		for _, call := range step.Calls {
+			fmt.Fprintf(&sb, "Call: %s(%s)\n", call.Name, call.Arguments)
+			fmt.Fprintf(&sb, "Result:\n%s\n", strings.TrimRight(call.Result, "\n"))
+		}
+	}
+	return sb.String()
+}
+
+// execSearchModels implements the search_models bot tool. Like the MCP tool,
+// it returns one ranked page, so a broad query cannot fill the bot's context
+// with the whole catalog.
+func execSearchModels(ctx context.Context, _ *sandbox, args string) (string, error) {
+	var in SearchModelsArgs
+	if args != "" {
+		if err := json.Unmarshal([]byte(args), &in); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	all, err := cache.get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetching models: %w", err)
+	}
+	if in.Limit == 0 {
+		in.Limit = defaultSearchLimit
+	}
+	page, err := searchModels(all, in)
+	if err != nil {
+		return "", err
+	}
+	if page.Total == 0 {
+		return "No models found matching the given criteria.", nil
+	}
+	return formatModelPage(page), nil
+}
+
+// execReadFile implements the read_file bot tool. Paths are confined to the
+// same sandbox as the caller's own attachments, including its deny patterns.
+func execReadFile(ctx context.Context, sb *sandbox, args string) (string, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(args), &in); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if in.Path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	return readFileInSandbox(sb, in.Path)
+}
+
+// readFileInSandbox reads a UTF-8 text file that sb allows. Relative names
+// are resolved against the first root. A nil sandbox is not accepted: a bot
+// must never be able to read arbitrary files.
+func readFileInSandbox(sb *sandbox, name string) (string, error) {
+	if sb == nil || len(sb.roots) == 0 {
+		return "", &accessError{Path: name, Reason: "cannot be read: no allowed roots"}
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(sb.roots[0], path)
+	}
+	path, err := sb.resolve(path)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("file %q: %w", name, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxReadFileBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("file %q: %w", name, err)
+	}
+	if len(data) > maxReadFileBytes {
+		return "", fmt.Errorf("file %q exceeds %d bytes", name, maxReadFileBytes)
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("file %q is not a UTF-8 text file", name)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0madic/go-poe/models"
+	"github.com/n0madic/go-poe/types"
+)
+
+func TestReadFileInSandbox(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "blob.bin"), []byte{0xff, 0xfe, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".env"), []byte("TOKEN=x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sb := newSandbox([]string{root}, denyPatterns())
+	got, err := readFileInSandbox(sb, "notes.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "hello" {
+		t.Errorf("content = %q, want %q", got, "hello")
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"parent traversal", "../" + filepath.Base(outside) + "/secret.txt", "outside"},
+		{"absolute path outside", filepath.Join(outside, "secret.txt"), "outside"},
+		{"symlink escape", "link.txt", "outside"},
+		{"binary file", "blob.bin", "not a UTF-8"},
+		{"missing file", "missing.txt", "no such file"},
+		{"denied file", ".env", "deny pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readFileInSandbox(sb, tt.path)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want substring %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadFileToolUsesSandbox(t *testing.T) {
+	allowed, other := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(allowed, "a.txt"), []byte("allowed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "b.txt"), []byte("other"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(c config) { cfg = c }(cfg)
+	cfg = config{AllowedRoots: []string{allowed}}
+
+	sb := sandboxForRequest(context.Background(), nil)
+	call := func(path string) ToolCallRecord {
+		return executeToolCall(context.Background(), types.ToolCallDefinition{
+			ID:       "call_1",
+			Function: types.FunctionCallDefinition{Name: "read_file", Arguments: `{"path":"` + path + `"}`},
+		}, []string{"read_file"}, sb)
+	}
+	if rec := call("a.txt"); rec.IsError || rec.Result != "allowed" {
+		t.Errorf("relative path in the configured root = %+v", rec)
+	}
+	// The working directory is not a root once allowed_roots is configured.
+	if rec := call(filepath.Join(other, "b.txt")); !rec.IsError || !strings.Contains(rec.Result, "outside the allowed roots") {
+		t.Errorf("path outside the configured root = %+v", rec)
+	}
+	if _, err := readFileInSandbox(nil, filepath.Join(allowed, "a.txt")); err == nil {
+		t.Error("expected a nil sandbox to refuse every path")
+	}
+}
+
+func TestExecSearchModelsPaged(t *testing.T) {
+	orig := cache
+	defer func() { cache = orig }()
+	var all []models.Model
+	for i := range 60 {
+		all = append(all, models.Model{ID: fmt.Sprintf("bot-%02d", i), OwnedBy: "Acme"})
+	}
+	cache = &modelCache{models: all, fetchedAt: time.Now()}
+
+	got, err := execSearchModels(context.Background(), nil, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "Found 60 model(s), showing 1-50") || strings.Contains(got, "bot-50") {
+		t.Errorf("default page =\n%s", got)
+	}
+
+	got, err = execSearchModels(context.Background(), nil, `{"sort_by":"id","order":"desc","limit":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(got, "## ") != 2 || !strings.Contains(got, "## bot-59") || !strings.Contains(got, "## bot-58") {
+		t.Errorf("sorted page =\n%s", got)
+	}
+
+	if _, err := execSearchModels(context.Background(), nil, `{"sort_by":"size"}`); err == nil {
+		t.Error("expected an error for an unknown sort key")
+	}
+}
+
+func TestExecuteToolCallNotEnabled(t *testing.T) {
+	call := types.ToolCallDefinition{
+		ID:       "call_1",
+		Type:     "function",
+		Function: types.FunctionCallDefinition{Name: "read_file", Arguments: `{"path":"go.mod"}`},
+	}
+	rec := executeToolCall(context.Background(), call, []string{"search_models"}, nil)
+	if !rec.IsError {
+		t.Error("expected error for tool that was not enabled")
+	}
+	if !strings.Contains(rec.Result, "not available") {
+		t.Errorf("result = %q, want 'not available'", rec.Result)
+	}
+}
+
+func TestRunToolLoopUnknownTool(t *testing.T) {
+	req := &types.QueryRequest{}
+	_, _, err := runToolLoop(context.Background(), req, "GPT-4o", "fake-key", []string{"rm_rf"}, 1, nil, nil)
+	if err == nil {
+		t.Fatal("expected error for unknown tool")
+	}
+	if !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("error = %q, want 'unknown tool'", err.Error())
+	}
+}
+
+func TestFormatTranscript(t *testing.T) {
+	steps := []ToolStep{{
+		Iteration: 1,
+		Text:      "Let me look that up.",
+		Calls: []ToolCallRecord{
+			{Name: "search_models", Arguments: `{"owned_by":"Google"}`, Result: "Found 1 model(s)"},
+		},
+	}}
+	out := formatTranscript(steps)
+	for _, want := range []string{"### Iteration 1", "Bot: Let me look that up.", `Call: search_models({"owned_by":"Google"})`, "Found 1 model(s)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("transcript missing %q\ngot: %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n0madic/go-poe/client"
+	"github.com/n0madic/go-poe/types"
+)
+
+// uploadOptions controls how attachments are prepared and uploaded.
+type uploadOptions struct {
+	NoCache           bool   // always re-upload, bypassing the upload cache
+	Bot               string // target bot, used to check input modalities
+	SkipModalityCheck bool   // send attachments the bot may not accept
+	MaxFiles          int    // cap on files from glob/directory expansion
+	MaxBytes          int    // cap on bytes from glob/directory expansion
+	Mode              attachMode
+	InlineLimit       int      // largest text file inlined; see inlineTextFiles
+	Stdin             []byte   // content attached for the "-" entry (CLI only)
+	StdinName         string   // file name for the stdin attachment
+	Sandbox           *sandbox // restricts local paths; nil allows any
+	MaxImageDimension int      // downscale images whose longer side exceeds this
+	MaxImageBytes     int      // re-encode images larger than this
+	KeepMetadata      bool     // upload images with EXIF, XMP and IPTC metadata
+	Convert           []string // Office and notebook files to convert to text
+}
+
+// uploadResult is what uploadFiles sends on to the bot.
+type uploadResult struct {
+	Attachments []types.Attachment
+	Inline      string         // text files to append to the message
+	Resized     []ResizedImage // images shrunk before upload
+	TextBytes   int64          // size of the text files among Attachments
+}
+
+// maxConcurrentUploads bounds how many files are uploaded in parallel.
+const maxConcurrentUploads = 4
+
+// fileFailure records why a single file could not be uploaded.
+type fileFailure struct {
+	Path string
+	Err  error
+}
+
+// uploadError reports every file that failed to upload, not just the first.
+type uploadError struct {
+	Failures []fileFailure
+	Total    int
+}
+
+func (e *uploadError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d of %d file(s) failed to upload:", len(e.Failures), e.Total)
+	for _, f := range e.Failures {
+		fmt.Fprintf(&sb, "\n- %s: %v", f.Path, f.Err)
+	}
+	return sb.String()
+}
+
+func (e *uploadError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// uploadFiles uploads each file path or URL and returns the resulting
+// attachments. Strings starting with http:// or https:// are treated as URLs;
+// everything else is treated as a local file path, directory, or glob pattern
+// (see prepareAttachments). When opts.Bot is set, attachments are checked
+// against the bot's input modalities before anything is uploaded.
+//
+// In web mode, URLs are fetched locally and web pages are sent as markdown
+// (see fetchWebPages). Office documents and notebooks selected by
+// opts.Convert are converted to text first (see convertDocuments). Images
+// over the configured limits are downscaled first (see resizeImages), and
+// image metadata is stripped unless opts.KeepMetadata is set. In inline and
+// auto mode, text files are not uploaded but returned as inline text (fenced
+// code blocks) to append to the message; see inlineTextFiles.
+//
+// Files are uploaded concurrently. A file that fails any step, from reading
+// and expanding it to the upload itself, is dropped without stopping the
+// others. If any fail, the attachments that did succeed are returned in
+// input order together with an *uploadError listing every failure, so
+// callers can choose to continue with a partial set.
+func uploadFiles(ctx context.Context, files []string, key string, opts uploadOptions) (*uploadResult, error) {
+	var failures []fileFailure
+	atts, err := prepareAttachments(files, opts)
+	if err != nil {
+		var upErr *uploadError
+		if !errors.As(err, &upErr) {
+			return nil, err
+		}
+		failures = append(failures, upErr.Failures...)
+	}
+	if opts.Mode == attachWeb {
+		atts = eachFile(atts, &failures, func(a attachment) ([]attachment, error) {
+			return fetchWebPages(ctx, []attachment{a})
+		})
+	}
+	atts = eachFile(atts, &failures, func(a attachment) ([]attachment, error) {
+		return convertDocuments([]attachment{a}, opts.Convert)
+	})
+	if opts.Bot != "" && !opts.SkipModalityCheck {
+		atts = eachFile(atts, &failures, func(a attachment) ([]attachment, error) {
+			return []attachment{a}, checkModalities(ctx, opts.Bot, []attachment{a})
+		})
+	}
+	res := &uploadResult{}
+	atts = eachFile(atts, &failures, func(a attachment) ([]attachment, error) {
+		out, resized, err := resizeImages([]attachment{a}, opts)
+		res.Resized = append(res.Resized, resized...)
+		return out, err
+	})
+	if !opts.KeepMetadata {
+		atts = eachFile(atts, &failures, func(a attachment) ([]attachment, error) {
+			return stripImageMetadata(ctx, []attachment{a})
+		})
+	}
+	total := len(failures) + len(atts)
+	res.Inline, atts = inlineTextFiles(atts, opts)
+
+	results := make([]*types.Attachment, len(atts))
+	errs := make([]error, len(atts))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentUploads)
+	for i, a := range atts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = uploadAttachment(ctx, a, key, opts)
+		}()
+	}
+	wg.Wait()
+
+	for i, a := range atts {
+		if errs[i] != nil {
+			failures = append(failures, fileFailure{Path: a.Source, Err: errs[i]})
+			continue
+		}
+		res.Attachments = append(res.Attachments, *results[i])
+		if text, ok := attachmentText(a, math.MaxInt64); ok {
+			res.TextBytes += int64(len(text))
+		}
+	}
+	if len(failures) > 0 {
+		return res, &uploadError{Failures: failures, Total: total}
+	}
+	return res, nil
+}
+
+// eachFile applies step to every attachment on its own, so a file the step
+// rejects is recorded in failures and dropped instead of failing the rest.
+func eachFile(atts []attachment, failures *[]fileFailure, step func(attachment) ([]attachment, error)) []attachment {
+	var out []attachment
+	for _, a := range atts {
+		res, err := step(a)
+		if err != nil {
+			*failures = append(*failures, fileFailure{Path: a.Source, Err: err})
+			continue
+		}
+		out = append(out, res...)
+	}
+	return out
+}
+
+// uploadAttachment uploads a prepared attachment from memory or from its path.
+func uploadAttachment(ctx context.Context, a attachment, key string, opts uploadOptions) (*types.Attachment, error) {
+	if a.Data != nil {
+		return uploadData(ctx, a.Name, a.Data, key, opts)
+	}
+	return uploadSingleFile(ctx, a.Path, key, opts)
+}
+
+// uploadData uploads in-memory content under the given file name.
+func uploadData(ctx context.Context, name string, data []byte, key string, opts uploadOptions) (*types.Attachment, error) {
+	cache := uploads
+	if opts.NoCache {
+		cache = nil
+	}
+	cacheKey, _ := contentCacheKey(bytes.NewReader(data), name, key)
+	if att, ok := cache.get(cacheKey); ok {
+		return att, nil
+	}
+	att, err := client.UploadFile(ctx, &client.UploadFileOptions{
+		File:     bytes.NewReader(data),
+		FileName: name,
+		APIKey:   key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cache.put(cacheKey, att)
+	return att, nil
+}
+
+// uploadSingleFile uploads a single file (local path or URL) and returns the attachment.
+// Previously uploaded content is served from the upload cache unless disabled.
+// URLs matching a header rule are downloaded locally with the rule's headers.
+func uploadSingleFile(ctx context.Context, path, key string, opts uploadOptions) (*types.Attachment, error) {
+	cache := uploads
+	if opts.NoCache {
+		cache = nil
+	}
+
+	if isURL(path) {
+		if err := cfg.URLPolicy.validate(ctx, path); err != nil {
+			return nil, err
+		}
+		header, err := cfg.headersFor(path)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			// Poe cannot send our credentials, so download the file here and
+			// upload its bytes.
+			fetched, err := cfg.URLPolicy.fetchURL(ctx, path, header)
+			if err != nil {
+				return nil, err
+			}
+			return uploadData(ctx, fetched.fileName(), fetched.Data, key, opts)
+		}
+		name := urlFileName(path)
+		cacheKey := urlCacheKey(cache, path, name, key)
+		if entry, ok := cache.lookup(cacheKey); ok && entry.ETag != "" && urlETag(ctx, path) == entry.ETag {
+			return &entry.Attachment, nil
+		}
+		// On a miss, fetch the ETag while Poe downloads the URL rather than
+		// before, so caching adds no latency.
+		etag := make(chan string, 1)
+		if cacheKey != "" {
+			go func() { etag <- urlETag(ctx, path) }()
+		}
+		att, err := client.UploadFile(ctx, &client.UploadFileOptions{
+			FileURL:  path,
+			FileName: name,
+			APIKey:   key,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if cacheKey != "" {
+			// URLs without an ETag are not cached, since their content may
+			// change without notice.
+			if tag := <-etag; tag != "" {
+				cache.store(cacheKey, att, tag)
+			}
+		}
+		return att, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	var cacheKey string
+	if cache != nil {
+		cacheKey, err = contentCacheKey(f, name, key)
+		if err != nil {
+			return nil, fmt.Errorf("file %q: %w", path, err)
+		}
+		if att, ok := cache.get(cacheKey); ok {
+			return att, nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("file %q: %w", path, err)
+		}
+	}
+
+	att, err := client.UploadFile(ctx, &client.UploadFileOptions{
+		File:     f,
+		FileName: name,
+		APIKey:   key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cache.put(cacheKey, att)
+	return att, nil
+}
+
+// urlETag returns the ETag reported by a HEAD request for url, made under
+// the URL policy, or "" if there is none.
+func urlETag(ctx context.Context, url string) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := cfg.URLPolicy.httpClient(10 * time.Second).Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	return resp.Header.Get("ETag")
+}
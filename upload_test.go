@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0madic/go-poe/types"
+)
+
+func TestUploadFilesValidation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		files   []string
+		wantErr string
+	}{
+		{
+			name:    "non-existent local file",
+			files:   []string{"/no/such/file.txt"},
+			wantErr: "no such file or directory",
+		},
+		{
+			name:    "private URL",
+			files:   []string{"http://127.0.0.1/secret"},
+			wantErr: "blocked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := uploadFiles(ctx, tt.files, "fake-key", uploadOptions{})
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want substring %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUploadFilesEmptySlice(t *testing.T) {
+	ctx := context.Background()
+	res, err := uploadFiles(ctx, nil, "fake-key", uploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Attachments) != 0 {
+		t.Errorf("expected 0 attachments, got %d", len(res.Attachments))
+	}
+}
+
+func TestUploadFilesReportsEveryFailure(t *testing.T) {
+	orig := uploads
+	defer func() { uploads = orig }()
+	uploads = newUploadCache("", time.Hour)
+
+	// Pre-populate the cache so one file "uploads" without network access.
+	good := filepath.Join(t.TempDir(), "good.txt")
+	if err := os.WriteFile(good, []byte("ok"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := contentCacheKey(strings.NewReader("ok"), "good.txt", "fake-key")
+	uploads.put(key, &types.Attachment{URL: "https://pfst.cf2.poecdn.net/good", Name: "good.txt"})
+
+	files := []string{"/no/such/a.txt", good, "/no/such/b.txt"}
+	res, err := uploadFiles(context.Background(), files, "fake-key", uploadOptions{})
+
+	var upErr *uploadError
+	if !errors.As(err, &upErr) {
+		t.Fatalf("expected *uploadError, got %v", err)
+	}
+	if upErr.Total != 3 || len(upErr.Failures) != 2 {
+		t.Errorf("failures = %d of %d, want 2 of 3", len(upErr.Failures), upErr.Total)
+	}
+	if upErr.Failures[0].Path != "/no/such/a.txt" || upErr.Failures[1].Path != "/no/such/b.txt" {
+		t.Errorf("failures not in input order: %+v", upErr.Failures)
+	}
+	for _, want := range []string{"2 of 3", "/no/such/a.txt", "/no/such/b.txt"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q missing %q", err.Error(), want)
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected uploadError to unwrap to fs.ErrNotExist")
+	}
+	if len(res.Attachments) != 1 || res.Attachments[0].Name != "good.txt" {
+		t.Errorf("attachments = %+v, want only good.txt", res.Attachments)
+	}
+}
+
+func TestUploadFilesFailuresFromEveryStep(t *testing.T) {
+	orig := uploads
+	defer func() { uploads = orig }()
+	uploads = newUploadCache("", time.Hour)
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.txt")
+	bad := filepath.Join(dir, "bad.docx")
+	bomb := filepath.Join(dir, "bomb.png")
+	for path, data := range map[string][]byte{
+		good: []byte("ok"),
+		bad:  []byte("not a zip"),
+		bomb: pngHeaderOnly(50000, 50000),
+	} {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, _ := contentCacheKey(strings.NewReader("ok"), "good.txt", "fake-key")
+	uploads.put(key, &types.Attachment{URL: "https://pfst.cf2.poecdn.net/good", Name: "good.txt"})
+
+	// Expansion, conversion and resizing each reject one file; the good
+	// file is still uploaded.
+	files := []string{filepath.Join(dir, "*.none"), good, bad, bomb}
+	res, err := uploadFiles(context.Background(), files, "fake-key", uploadOptions{
+		Convert:           []string{"*"},
+		MaxImageDimension: 1024,
+	})
+	var upErr *uploadError
+	if !errors.As(err, &upErr) {
+		t.Fatalf("expected *uploadError, got %v", err)
+	}
+	if upErr.Total != 4 || len(upErr.Failures) != 3 {
+		t.Errorf("failures = %d of %d, want 3 of 4: %v", len(upErr.Failures), upErr.Total, err)
+	}
+	for _, want := range []string{"matched no files", "bad.docx", "megapixel limit"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q missing %q", err.Error(), want)
+		}
+	}
+	if len(res.Attachments) != 1 || res.Attachments[0].Name != "good.txt" {
+		t.Errorf("attachments = %+v, want only good.txt", res.Attachments)
+	}
+}
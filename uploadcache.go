@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/n0madic/go-poe/types"
+)
+
+// uploadCacheTTL is how long an uploaded attachment is reused. Poe attachment
+// URLs stay valid for a limited time, so entries expire well before that.
+const uploadCacheTTL = time.Hour
+
+// uploadCacheEntry is a cached upload result. ETag is set for URL
+// attachments and must still match the URL's for the entry to be reused.
+type uploadCacheEntry struct {
+	Attachment types.Attachment `json:"attachment"`
+	ETag       string           `json:"etag,omitempty"`
+	StoredAt   time.Time        `json:"stored_at"`
+}
+
+// uploadCache maps content hashes to attachments that were already uploaded to
+// Poe, so the same file is not re-uploaded on every query. Keys include the
+// API key, since an attachment belongs to the account that uploaded it.
+//
+// Entries live in memory and, when dir is set, in JSON files under dir so
+// they survive across CLI invocations. A nil *uploadCache is valid and never
+// hits.
+type uploadCache struct {
+	mu      sync.Mutex
+	entries map[string]uploadCacheEntry
+	dir     string
+	ttl     time.Duration
+}
+
+// uploads is the process-wide upload cache. Set POE_MCP_UPLOAD_CACHE_DIR to
+// also persist entries on disk.
+var uploads = newUploadCache(os.Getenv("POE_MCP_UPLOAD_CACHE_DIR"), uploadCacheTTL)
+
+func newUploadCache(dir string, ttl time.Duration) *uploadCache {
+	return &uploadCache{
+		entries: make(map[string]uploadCacheEntry),
+		dir:     dir,
+		ttl:     ttl,
+	}
+}
+
+// get returns the cached attachment for key if it has not expired.
+func (c *uploadCache) get(key string) (*types.Attachment, bool) {
+	entry, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return &entry.Attachment, true
+}
+
+// lookup returns the cache entry for key if it has not expired.
+func (c *uploadCache) lookup(key string) (uploadCacheEntry, bool) {
+	if c == nil || key == "" {
+		return uploadCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok && c.dir != "" {
+		entry, ok = c.load(key)
+	}
+	if !ok {
+		return uploadCacheEntry{}, false
+	}
+	if time.Since(entry.StoredAt) >= c.ttl {
+		delete(c.entries, key)
+		if c.dir != "" {
+			os.Remove(c.path(key))
+		}
+		return uploadCacheEntry{}, false
+	}
+	c.entries[key] = entry
+	return entry, true
+}
+
+// put stores att under key.
+func (c *uploadCache) put(key string, att *types.Attachment) {
+	c.store(key, att, "")
+}
+
+// store stores att under key along with the ETag of the URL it was uploaded
+// from, if any. Disk write failures are ignored; the in-memory entry is still
+// used.
+func (c *uploadCache) store(key string, att *types.Attachment, etag string) {
+	if c == nil || key == "" || att == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := uploadCacheEntry{Attachment: *att, ETag: etag, StoredAt: time.Now()}
+	c.entries[key] = entry
+	if c.dir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return
+	}
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return
+	}
+	os.Rename(tmp, c.path(key))
+}
+
+func (c *uploadCache) load(key string) (uploadCacheEntry, bool) {
+	var entry uploadCacheEntry
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *uploadCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// hashKey derives a cache key from the given parts.
+func hashKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		io.WriteString(h, p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contentCacheKey derives a cache key from the SHA-256 of r's content, the
+// attachment name, since the name is part of what the bot sees, and the API
+// key the attachment is uploaded with. The API key only enters the hash.
+func contentCacheKey(r io.Reader, name, apiKey string) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hashKey("content", hex.EncodeToString(h.Sum(nil)), name, apiKey), nil
+}
+
+// urlCacheKey derives the cache key for a URL attachment uploaded with
+// apiKey, or "" when cache is disabled. The entry's ETag decides whether it
+// is still current.
+func urlCacheKey(cache *uploadCache, url, name, apiKey string) string {
+	if cache == nil {
+		return ""
+	}
+	return hashKey("url", url, name, apiKey)
+}
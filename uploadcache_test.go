@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0madic/go-poe/types"
+)
+
+func TestUploadCacheMemory(t *testing.T) {
+	c := newUploadCache("", time.Hour)
+	att := &types.Attachment{URL: "https://pfst.cf2.poecdn.net/a", Name: "a.txt", ContentType: "text/plain"}
+
+	if _, ok := c.get("k"); ok {
+		t.Fatal("unexpected hit on empty cache")
+	}
+	c.put("k", att)
+	got, ok := c.get("k")
+	if !ok || got.URL != att.URL {
+		t.Fatalf("get = %v, %v; want %v", got, ok, att)
+	}
+
+	// Mutating the returned attachment must not affect the cache.
+	got.Name = "changed"
+	if again, _ := c.get("k"); again.Name != "a.txt" {
+		t.Error("cache entry was mutated through returned pointer")
+	}
+}
+
+func TestUploadCacheExpiry(t *testing.T) {
+	c := newUploadCache("", time.Hour)
+	c.entries["k"] = uploadCacheEntry{
+		Attachment: types.Attachment{URL: "u"},
+		StoredAt:   time.Now().Add(-2 * time.Hour),
+	}
+	if _, ok := c.get("k"); ok {
+		t.Error("expected expired entry to miss")
+	}
+	if _, ok := c.entries["k"]; ok {
+		t.Error("expected expired entry to be evicted")
+	}
+}
+
+func TestUploadCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	att := &types.Attachment{URL: "https://pfst.cf2.poecdn.net/b", Name: "b.pdf"}
+
+	newUploadCache(dir, time.Hour).put("k", att)
+	if _, err := os.Stat(filepath.Join(dir, "k.json")); err != nil {
+		t.Fatalf("expected cache file: %v", err)
+	}
+
+	// A fresh cache (e.g. the next CLI run) reads the entry from disk.
+	got, ok := newUploadCache(dir, time.Hour).get("k")
+	if !ok || got.URL != att.URL {
+		t.Errorf("disk get = %v, %v; want %v", got, ok, att)
+	}
+}
+
+func TestUploadCacheNil(t *testing.T) {
+	var c *uploadCache
+	c.put("k", &types.Attachment{URL: "u"})
+	if _, ok := c.get("k"); ok {
+		t.Error("nil cache must never hit")
+	}
+}
+
+func TestContentCacheKey(t *testing.T) {
+	k1, _ := contentCacheKey(strings.NewReader("same"), "a.txt", "key-a")
+	k2, _ := contentCacheKey(strings.NewReader("same"), "a.txt", "key-a")
+	k3, _ := contentCacheKey(strings.NewReader("other"), "a.txt", "key-a")
+	k4, _ := contentCacheKey(strings.NewReader("same"), "b.txt", "key-a")
+	k5, _ := contentCacheKey(strings.NewReader("same"), "a.txt", "key-b")
+	if k1 != k2 {
+		t.Error("identical content, name and API key should produce the same key")
+	}
+	if k1 == k3 || k1 == k4 {
+		t.Error("different content or name should produce different keys")
+	}
+	if k1 == k5 {
+		t.Error("uploads under another API key must not share a key")
+	}
+	if strings.Contains(k1, "key-a") {
+		t.Error("the API key must only enter the hash")
+	}
+}
+
+func TestURLCacheKey(t *testing.T) {
+	c := newUploadCache("", time.Hour)
+	k1 := urlCacheKey(c, "https://example.com/a.pdf", "a.pdf", "key-a")
+	if k1 == "" {
+		t.Fatal("expected key when the cache is enabled")
+	}
+	if k2 := urlCacheKey(c, "https://example.com/a.pdf", "a.pdf", "key-b"); k2 == k1 {
+		t.Error("uploads under another API key must not share a key")
+	}
+	if k := urlCacheKey(nil, "https://example.com/a.pdf", "a.pdf", "key-a"); k != "" {
+		t.Error("expected no key when cache is disabled")
+	}
+}
+
+func TestURLETag(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tagged" {
+			w.Header().Set("ETag", `"v1"`)
+		}
+	}))
+	defer srv.Close()
+
+	// The test server listens on loopback, which the URL policy blocks by default.
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg.URLPolicy.AllowPrivate = true
+
+	ctx := context.Background()
+	if got := urlETag(ctx, srv.URL+"/tagged"); got != `"v1"` {
+		t.Errorf("urlETag = %q, want %q", got, `"v1"`)
+	}
+	if got := urlETag(ctx, srv.URL+"/plain"); got != "" {
+		t.Errorf("urlETag without ETag = %q, want empty", got)
+	}
+}
+
+func TestUploadSingleFileURLCacheHit(t *testing.T) {
+	heads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		heads++
+		w.Header().Set("ETag", `"v1"`)
+	}))
+	defer srv.Close()
+
+	origCfg, origUploads := cfg, uploads
+	defer func() { cfg, uploads = origCfg, origUploads }()
+	cfg.URLPolicy.AllowPrivate = true
+	uploads = newUploadCache("", time.Hour)
+
+	url := srv.URL + "/report.pdf"
+	cached := &types.Attachment{URL: "https://pfst.cf2.poecdn.net/cached", Name: "report.pdf"}
+	uploads.store(urlCacheKey(uploads, url, urlFileName(url), "fake-key"), cached, `"v1"`)
+
+	// A validated hit returns without contacting Poe, so a fake key succeeds.
+	att, err := uploadSingleFile(context.Background(), url, "fake-key", uploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if att.URL != cached.URL {
+		t.Errorf("attachment = %v, want cached %v", att, cached)
+	}
+	if heads != 1 {
+		t.Errorf("HEAD requests = %d, want 1", heads)
+	}
+}
+
+func TestUploadSingleFileCacheHit(t *testing.T) {
+	orig := uploads
+	defer func() { uploads = orig }()
+	uploads = newUploadCache("", time.Hour)
+
+	path := filepath.Join(t.TempDir(), "doc.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(path)
+	key, _ := contentCacheKey(f, "doc.txt", "fake-key")
+	f.Close()
+	cached := &types.Attachment{URL: "https://pfst.cf2.poecdn.net/cached", Name: "doc.txt"}
+	uploads.put(key, cached)
+
+	// A cache hit returns without contacting Poe, so a fake key succeeds.
+	att, err := uploadSingleFile(context.Background(), path, "fake-key", uploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if att.URL != cached.URL {
+		t.Errorf("URL = %q, want cached %q", att.URL, cached.URL)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// headerRule adds request headers, such as Authorization or Cookie, to URL
+// downloads from matching hosts. URL attachments that match a rule are
+// downloaded locally and uploaded as bytes, so the headers never reach Poe.
+type headerRule struct {
+	Hosts   []string          `json:"hosts"`             // host or parent domain, as in urlPolicy
+	Headers map[string]string `json:"headers,omitempty"` // header name -> value
+	// HeadersFromEnv maps header names to environment variables holding their
+	// values, keeping secrets out of the config file.
+	HeadersFromEnv map[string]string `json:"headers_from_env,omitempty"`
+	// AllowHTTP also applies the rule to plain http:// URLs. By default
+	// credentials are only sent over https.
+	AllowHTTP bool `json:"allow_http,omitempty"`
+}
+
+// validate reports rules that could never apply.
+func (r headerRule) validate(i int) error {
+	if len(r.Hosts) == 0 {
+		return fmt.Errorf("url_headers[%d]: hosts is required", i)
+	}
+	if len(r.Headers) == 0 && len(r.HeadersFromEnv) == 0 {
+		return fmt.Errorf("url_headers[%d]: no headers or headers_from_env", i)
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to u.
+func (r headerRule) matches(u *url.URL) bool {
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+	case "http":
+		if !r.AllowHTTP {
+			return false
+		}
+	default:
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, h := range r.Hosts {
+		if hostMatches(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// headersFor returns the headers every matching rule adds to a download of
+// raw, or nil if no rule matches. Later rules override earlier ones. A
+// referenced environment variable that is unset is an error, rather than a
+// silent unauthenticated request.
+func (c config) headersFor(raw string) (http.Header, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, nil
+	}
+	var h http.Header
+	for _, r := range c.URLHeaders {
+		if !r.matches(u) {
+			continue
+		}
+		if h == nil {
+			h = http.Header{}
+		}
+		for k, v := range r.Headers {
+			h.Set(k, v)
+		}
+		for k, env := range r.HeadersFromEnv {
+			v, ok := os.LookupEnv(env)
+			if !ok || v == "" {
+				return nil, fmt.Errorf("header %s for %s: environment variable %s is not set", k, u.Host, env)
+			}
+			h.Set(k, v)
+		}
+	}
+	return h, nil
+}
+
+// fetch downloads raw under the URL policy with the headers of any matching
+// rule.
+func (c config) fetch(ctx context.Context, raw string) (*fetchedURL, error) {
+	header, err := c.headersFor(raw)
+	if err != nil {
+		return nil, err
+	}
+	return c.URLPolicy.fetchURL(ctx, raw, header)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHeadersFor(t *testing.T) {
+	t.Setenv("CI_TOKEN", "Bearer secret")
+	c := config{URLHeaders: []headerRule{
+		{Hosts: []string{"ci.example.com"}, HeadersFromEnv: map[string]string{"Authorization": "CI_TOKEN"}},
+		{Hosts: []string{"example.com"}, Headers: map[string]string{"Cookie": "session=abc"}},
+		{Hosts: []string{"wiki.internal"}, Headers: map[string]string{"X-Token": "t"}, AllowHTTP: true},
+	}}
+	tests := []struct {
+		url  string
+		want map[string]string
+	}{
+		{"https://ci.example.com/job/1/log", map[string]string{"Authorization": "Bearer secret", "Cookie": "session=abc"}},
+		{"https://docs.example.com/a", map[string]string{"Cookie": "session=abc"}},
+		{"http://docs.example.com/a", nil}, // credentials only over https
+		{"http://wiki.internal/page", map[string]string{"X-Token": "t"}},
+		{"https://other.org/", nil},
+	}
+	for _, tt := range tests {
+		h, err := c.headersFor(tt.url)
+		if err != nil {
+			t.Fatalf("headersFor(%s): %v", tt.url, err)
+		}
+		if len(h) != len(tt.want) {
+			t.Errorf("headersFor(%s) = %v, want %v", tt.url, h, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if h.Get(k) != v {
+				t.Errorf("headersFor(%s)[%s] = %q, want %q", tt.url, k, h.Get(k), v)
+			}
+		}
+	}
+
+	c.URLHeaders[0].HeadersFromEnv["Authorization"] = "MISSING_TOKEN"
+	if _, err := c.headersFor("https://ci.example.com/x"); err == nil || !strings.Contains(err.Error(), "MISSING_TOKEN") {
+		t.Errorf("err = %v, want an unset variable error", err)
+	}
+}
+
+func TestFetchWithHeaders(t *testing.T) {
+	var seen []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, "other:"+r.Header.Get("X-Token")+":"+r.Header.Get("Authorization"))
+		w.Write([]byte("redirected"))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, "origin:"+r.Header.Get("X-Token"))
+		if r.URL.Path == "/away" {
+			// localhost is another hostname for the same loopback address.
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/file", http.StatusFound)
+			return
+		}
+		if r.Header.Get("X-Token") != "t" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("build log"))
+	}))
+	defer srv.Close()
+
+	defer func(c config) { cfg = c }(cfg)
+	cfg = config{
+		URLPolicy: urlPolicy{AllowPrivate: true},
+		URLHeaders: []headerRule{{
+			Hosts:     []string{"127.0.0.1"},
+			Headers:   map[string]string{"X-Token": "t", "Authorization": "Basic x"},
+			AllowHTTP: true,
+		}},
+	}
+
+	f, err := cfg.fetch(context.Background(), srv.URL+"/log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Data) != "build log" || f.fileName() != "log.txt" {
+		t.Errorf("fetched %q as %s", f.Data, f.fileName())
+	}
+
+	seen = nil
+	if _, err := cfg.fetch(context.Background(), srv.URL+"/away"); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0] != "origin:t" || seen[1] != "other::" {
+		t.Errorf("requests = %q, want credentials only on the original host", seen)
+	}
+}
+
+func TestLoadConfig_URLHeaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"url_headers": [{"hosts": ["ci.example.com"], "headers_from_env": {"Authorization": "CI_TOKEN"}}]}`)
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.URLHeaders) != 1 || c.URLHeaders[0].HeadersFromEnv["Authorization"] != "CI_TOKEN" {
+		t.Errorf("URLHeaders = %+v", c.URLHeaders)
+	}
+
+	write(`{"url_headers": [{"headers": {"Cookie": "a=b"}}]}`)
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "hosts") {
+		t.Errorf("err = %v, want a missing hosts error", err)
+	}
+	write(`{"url_headers": [{"hosts": ["x.com"]}]}`)
+	if _, err := loadConfig(path); err == nil {
+		t.Error("expected error for a rule without headers")
+	}
+}
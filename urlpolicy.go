@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxDownloadBytes = 20 * 1024 * 1024
+	defaultMaxRedirects     = 5
+	fetchTimeout            = 30 * time.Second
+)
+
+// urlPolicy restricts which URLs may be attached or fetched. It applies both
+// to URLs Poe fetches on our behalf and to URLs fetched locally. Host entries
+// match the host itself and all of its subdomains.
+type urlPolicy struct {
+	AllowSchemes     []string `json:"allow_schemes,omitempty"` // default http, https
+	AllowHosts       []string `json:"allow_hosts,omitempty"`   // if set, only these hosts
+	DenyHosts        []string `json:"deny_hosts,omitempty"`
+	AllowPrivate     bool     `json:"allow_private,omitempty"`      // allow loopback, private and link-local addresses
+	MaxDownloadBytes int64    `json:"max_download_bytes,omitempty"` // default 20 MB
+	MaxRedirects     int      `json:"max_redirects,omitempty"`      // default 5; negative disables redirects
+}
+
+// urlPolicyError reports a URL rejected by the policy.
+type urlPolicyError struct {
+	URL    string
+	Reason string
+}
+
+func (e *urlPolicyError) Error() string {
+	return fmt.Sprintf("URL %q blocked: %s", e.URL, e.Reason)
+}
+
+// cgnat is the carrier-grade NAT range, which net.IP.IsPrivate does not cover.
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func (p urlPolicy) schemes() []string {
+	if len(p.AllowSchemes) == 0 {
+		return []string{"http", "https"}
+	}
+	return lowerAll(p.AllowSchemes)
+}
+
+func (p urlPolicy) maxBytes() int64 {
+	if p.MaxDownloadBytes <= 0 {
+		return defaultMaxDownloadBytes
+	}
+	return p.MaxDownloadBytes
+}
+
+func (p urlPolicy) maxRedirects() int {
+	switch {
+	case p.MaxRedirects < 0:
+		return 0
+	case p.MaxRedirects == 0:
+		return defaultMaxRedirects
+	default:
+		return p.MaxRedirects
+	}
+}
+
+// checkURL validates the scheme and host of u without resolving it.
+func (p urlPolicy) checkURL(u *url.URL) error {
+	raw := u.String()
+	if !containsString(p.schemes(), strings.ToLower(u.Scheme)) {
+		return &urlPolicyError{URL: raw, Reason: fmt.Sprintf("scheme %q is not allowed", u.Scheme)}
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return &urlPolicyError{URL: raw, Reason: "missing host"}
+	}
+	if u.User != nil {
+		return &urlPolicyError{URL: raw, Reason: "credentials in URLs are not allowed"}
+	}
+	for _, h := range p.DenyHosts {
+		if hostMatches(host, h) {
+			return &urlPolicyError{URL: raw, Reason: fmt.Sprintf("host %q is denied", host)}
+		}
+	}
+	if len(p.AllowHosts) > 0 {
+		allowed := false
+		for _, h := range p.AllowHosts {
+			allowed = allowed || hostMatches(host, h)
+		}
+		if !allowed {
+			return &urlPolicyError{URL: raw, Reason: fmt.Sprintf("host %q is not in the allowed hosts", host)}
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(raw, ip)
+	}
+	return nil
+}
+
+// checkIP rejects private, loopback, link-local and other non-public
+// addresses unless AllowPrivate is set.
+func (p urlPolicy) checkIP(raw string, ip net.IP) error {
+	if p.AllowPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip) {
+		return &urlPolicyError{URL: raw, Reason: fmt.Sprintf("address %s is not public", ip)}
+	}
+	return nil
+}
+
+// hostMatches reports whether host equals pattern or is a subdomain of it.
+func hostMatches(host, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(pattern), "*."))
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// validate checks raw against the policy and resolves its host, rejecting it
+// if any address is not public. It is used for URLs that Poe fetches, where
+// the connection itself cannot be checked.
+func (p urlPolicy) validate(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return &urlPolicyError{URL: raw, Reason: err.Error()}
+	}
+	if err := p.checkURL(u); err != nil {
+		return err
+	}
+	if p.AllowPrivate || net.ParseIP(u.Hostname()) != nil {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return &urlPolicyError{URL: raw, Reason: fmt.Sprintf("cannot resolve host: %v", err)}
+	}
+	for _, a := range addrs {
+		if err := p.checkIP(raw, a.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// policyTransports holds one transport for each AllowPrivate setting, the
+// only part of a policy the dialler depends on, so connections are pooled
+// across fetches rather than left idle by a new transport per request.
+var (
+	policyTransportsMu sync.Mutex
+	policyTransports   = make(map[bool]*http.Transport)
+)
+
+// transport returns the shared transport for the policy. Addresses are
+// checked when dialling, so DNS rebinding cannot bypass the private address
+// check, and proxies are not used since they would hide the real destination.
+func (p urlPolicy) transport() *http.Transport {
+	policyTransportsMu.Lock()
+	defer policyTransportsMu.Unlock()
+	if t, ok := policyTransports[p.AllowPrivate]; ok {
+		return t
+	}
+	check := urlPolicy{AllowPrivate: p.AllowPrivate}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("unexpected address %q", address)
+			}
+			return check.checkIP(address, ip)
+		},
+	}
+	t := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     30 * time.Second,
+	}
+	policyTransports[p.AllowPrivate] = t
+	return t
+}
+
+// httpClient returns a client for local fetches that enforces the policy on
+// every connection and redirect.
+func (p urlPolicy) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: p.transport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > p.maxRedirects() {
+				return fmt.Errorf("stopped after %d redirects", p.maxRedirects())
+			}
+			return p.checkURL(req.URL)
+		},
+	}
+}
+
+// fetchedURL is the content of a URL downloaded locally.
+type fetchedURL struct {
+	Data        []byte
+	ContentType string
+	FinalURL    string // after redirects
+	ETag        string
+}
+
+// fileName names a downloaded file after the last segment of its URL path,
+// falling back to "download" with an extension for its content type.
+func (f *fetchedURL) fileName() string {
+	if u, err := url.Parse(f.FinalURL); err == nil {
+		if name := path.Base(u.Path); name != "/" && name != "." && name != "" {
+			return name
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(f.ContentType)
+	return "download" + extensionForMIME(mediaType)
+}
+
+// redirectKeepsHeaders reports whether credentials sent to orig may follow a
+// redirect to next: only to the same hostname, on any port, and never from
+// HTTPS down to plain HTTP.
+func redirectKeepsHeaders(orig, next *url.URL) bool {
+	if strings.EqualFold(orig.Scheme, "https") && !strings.EqualFold(next.Scheme, "https") {
+		return false
+	}
+	return strings.EqualFold(orig.Hostname(), next.Hostname())
+}
+
+// fetchURL downloads raw under the policy, failing if the body exceeds the
+// download limit. Extra request headers may be supplied; they are not sent
+// after a redirect to another host.
+func (p urlPolicy) fetchURL(ctx context.Context, raw string, header http.Header) (*fetchedURL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, &urlPolicyError{URL: raw, Reason: err.Error()}
+	}
+	if err := p.checkURL(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := p.httpClient(fetchTimeout)
+	if len(header) > 0 {
+		// The client forwards custom headers on redirects; drop them when
+		// they would leave the original host so credentials stay with it.
+		checkRedirect := client.CheckRedirect
+		client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+			if !redirectKeepsHeaders(u, r.URL) {
+				for k := range header {
+					r.Header.Del(k)
+				}
+			}
+			return checkRedirect(r, via)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, unwrapPolicyError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %q: %s", raw, resp.Status)
+	}
+
+	limit := p.maxBytes()
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("URL %q is %d bytes, over the %d byte download limit", raw, resp.ContentLength, limit)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %q: %w", raw, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("URL %q exceeds the %d byte download limit", raw, limit)
+	}
+	return &fetchedURL{
+		Data:        data,
+		ContentType: resp.Header.Get("Content-Type"),
+		FinalURL:    resp.Request.URL.String(),
+		ETag:        resp.Header.Get("ETag"),
+	}, nil
+}
+
+// unwrapPolicyError surfaces a policy violation buried in a transport error,
+// so callers see why a redirect or connection was refused.
+func unwrapPolicyError(err error) error {
+	var pe *urlPolicyError
+	if errors.As(err, &pe) {
+		return pe
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLPolicyCheckURL(t *testing.T) {
+	p := urlPolicy{DenyHosts: []string{"evil.example"}, AllowHosts: []string{"example.com", "evil.example"}}
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/a.pdf", true},
+		{"https://cdn.example.com/a.pdf", true},
+		{"https://notexample.com/a.pdf", false},
+		{"https://evil.example/x", false},
+		{"ftp://example.com/a.pdf", false},
+		{"https://user:pw@example.com/a.pdf", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if err := p.checkURL(u); (err == nil) != tt.ok {
+			t.Errorf("checkURL(%q) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestURLPolicyCheckIP(t *testing.T) {
+	var p urlPolicy
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "169.254.169.254", "100.64.0.1", "::1", "fe80::1", "0.0.0.0"} {
+		if err := p.checkIP(addr, net.ParseIP(addr)); err == nil {
+			t.Errorf("checkIP(%s) allowed a non-public address", addr)
+		}
+	}
+	if err := p.checkIP("8.8.8.8", net.ParseIP("8.8.8.8")); err != nil {
+		t.Errorf("checkIP(8.8.8.8) = %v", err)
+	}
+	p.AllowPrivate = true
+	if err := p.checkIP("127.0.0.1", net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("AllowPrivate did not allow loopback: %v", err)
+	}
+}
+
+func TestURLPolicyValidateLiteralIP(t *testing.T) {
+	var p urlPolicy
+	err := p.validate(context.Background(), "http://169.254.169.254/latest/meta-data/")
+	var pe *urlPolicyError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *urlPolicyError, got %v", err)
+	}
+}
+
+func TestFetchURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/doc.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("hello " + r.Header.Get("X-Test")))
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx := context.Background()
+
+	// The test server listens on loopback, which is blocked by default.
+	var pe *urlPolicyError
+	if _, err := (urlPolicy{}).fetchURL(ctx, srv.URL+"/doc.txt", nil); !errors.As(err, &pe) {
+		t.Errorf("loopback fetch error = %v, want *urlPolicyError", err)
+	}
+
+	p := urlPolicy{AllowPrivate: true, MaxDownloadBytes: 50, MaxRedirects: 2}
+	got, err := p.fetchURL(ctx, srv.URL+"/doc.txt", http.Header{"X-Test": {"world"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got.Data) != "hello world" || got.ETag != `"v1"` {
+		t.Errorf("fetched %q etag %q", got.Data, got.ETag)
+	}
+
+	if _, err := p.fetchURL(ctx, srv.URL+"/big", nil); err == nil || !strings.Contains(err.Error(), "download limit") {
+		t.Errorf("oversized fetch error = %v", err)
+	}
+	if _, err := p.fetchURL(ctx, srv.URL+"/loop", nil); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("redirect loop error = %v", err)
+	}
+}
+
+func TestRedirectKeepsHeaders(t *testing.T) {
+	tests := []struct {
+		orig, next string
+		want       bool
+	}{
+		{"https://ci.example.com/log", "https://ci.example.com/raw/log", true},
+		{"https://ci.example.com/log", "https://CI.example.com:8443/log", true},
+		{"http://ci.example.com/log", "https://ci.example.com/log", true},
+		{"https://ci.example.com/log", "http://ci.example.com/log", false},
+		{"https://ci.example.com/log", "https://cdn.example.com/log", false},
+		{"https://ci.example.com/log", "https://ci.example.com.evil.test/log", false},
+	}
+	for _, tt := range tests {
+		orig, _ := url.Parse(tt.orig)
+		next, _ := url.Parse(tt.next)
+		if got := redirectKeepsHeaders(orig, next); got != tt.want {
+			t.Errorf("redirectKeepsHeaders(%s, %s) = %v, want %v", tt.orig, tt.next, got, tt.want)
+		}
+	}
+}
+
+func TestURLPolicyTransportShared(t *testing.T) {
+	a := urlPolicy{MaxRedirects: 1}.httpClient(time.Second).Transport
+	b := urlPolicy{DenyHosts: []string{"example.com"}}.httpClient(time.Second).Transport
+	if a != b {
+		t.Error("policies with the same AllowPrivate should share a transport")
+	}
+	if c := (urlPolicy{AllowPrivate: true}).httpClient(time.Second).Transport; c == a {
+		t.Error("AllowPrivate must not share a transport with the default policy")
+	}
+}
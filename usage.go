@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/n0madic/go-poe/client"
+	"github.com/n0madic/go-poe/models"
+	"github.com/n0madic/go-poe/types"
+)
+
+// charsPerToken is the rough text-to-token ratio used when Poe does not report
+// token counts.
+const charsPerToken = 4
+
+// QueryUsage reports token usage and estimated cost for one query.
+type QueryUsage struct {
+	PromptTokens     int            `json:"prompt_tokens" jsonschema:"Input tokens"`
+	CompletionTokens int            `json:"completion_tokens" jsonschema:"Output tokens"`
+	Images           int            `json:"images,omitempty" jsonschema:"Image attachments sent or received"`
+	Requests         int            `json:"requests" jsonschema:"Requests sent to Poe, including tool loop round trips and failed attempts"`
+	Estimated        bool           `json:"estimated" jsonschema:"True when token counts are approximated from text length rather than reported by Poe"`
+	CostUSD          *float64       `json:"cost_usd,omitempty" jsonschema:"Estimated cost in USD from catalog pricing; absent when the bot has no pricing"`
+	Reported         map[string]any `json:"reported,omitempty" jsonschema:"Usage or cost data reported in the last response stream that had any"`
+}
+
+// estimateTokens approximates the token count of s.
+func estimateTokens(s string) int {
+	n := utf8.RuneCountInString(s)
+	return (n + charsPerToken - 1) / charsPerToken
+}
+
+// parsePrice parses a catalog price string (USD per unit); missing or invalid
+// prices are reported as not ok.
+func parsePrice(p *string) (float64, bool) {
+	if p == nil || *p == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(*p, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// countImages returns how many attachments are images, by content type or,
+// when that is missing, by file name.
+func countImages(atts []types.Attachment) int {
+	n := 0
+	for _, att := range atts {
+		contentType := att.ContentType
+		if contentType == "" {
+			contentType = mimeFromExt(filepath.Ext(att.Name))
+		}
+		if strings.HasPrefix(contentType, "image/") {
+			n++
+		}
+	}
+	return n
+}
+
+// newQueryUsage builds usage from the prompt and response text, preferring
+// token counts reported in the stream's JSON data events.
+func newQueryUsage(promptText, completionText string, images int, data []map[string]any) *QueryUsage {
+	u := &QueryUsage{
+		PromptTokens:     estimateTokens(promptText),
+		CompletionTokens: estimateTokens(completionText),
+		Images:           images,
+		Estimated:        true,
+		Reported:         reportedUsage(data),
+	}
+	prompt, okPrompt := intField(u.Reported, "prompt_tokens", "input_tokens")
+	completion, okCompletion := intField(u.Reported, "completion_tokens", "output_tokens")
+	if okPrompt && okCompletion {
+		u.PromptTokens = prompt
+		u.CompletionTokens = completion
+		u.Estimated = false
+	}
+	return u
+}
+
+// reportedUsage merges any "usage" objects and cost fields found in the
+// response's JSON data events.
+func reportedUsage(data []map[string]any) map[string]any {
+	var out map[string]any
+	set := func(k string, v any) {
+		if out == nil {
+			out = make(map[string]any)
+		}
+		out[k] = v
+	}
+	for _, d := range data {
+		if usage, ok := d["usage"].(map[string]any); ok {
+			for k, v := range usage {
+				set(k, v)
+			}
+		}
+		for _, k := range []string{"cost", "cost_usd", "cost_items"} {
+			if v, ok := d[k]; ok {
+				set(k, v)
+			}
+		}
+	}
+	return out
+}
+
+// intField returns the first of keys present in m as an integer.
+func intField(m map[string]any, keys ...string) (int, bool) {
+	for _, k := range keys {
+		if v, ok := m[k].(float64); ok {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// applyPricing sets u.CostUSD from the model's catalog pricing. Prompt and
+// completion prices are per token, request per request sent, image per image.
+func (u *QueryUsage) applyPricing(p *models.Pricing) {
+	if p == nil {
+		return
+	}
+	var cost float64
+	priced := false
+	if v, ok := parsePrice(p.Prompt); ok {
+		cost += v * float64(u.PromptTokens)
+		priced = true
+	}
+	if v, ok := parsePrice(p.Completion); ok {
+		cost += v * float64(u.CompletionTokens)
+		priced = true
+	}
+	if v, ok := parsePrice(p.Request); ok {
+		cost += v * float64(max(u.Requests, 1))
+		priced = true
+	}
+	if v, ok := parsePrice(p.Image); ok {
+		cost += v * float64(u.Images)
+		priced = true
+	}
+	if priced {
+		u.CostUSD = &cost
+	}
+}
+
+// String formats usage as a one-line summary.
+func (u *QueryUsage) String() string {
+	if u == nil {
+		return "unknown"
+	}
+	approx := ""
+	if u.Estimated {
+		approx = "~"
+	}
+	s := fmt.Sprintf("%s%d prompt + %s%d completion tokens", approx, u.PromptTokens, approx, u.CompletionTokens)
+	if u.Requests > 1 {
+		s += fmt.Sprintf(" over %d requests", u.Requests)
+	}
+	if u.Images > 0 {
+		s += fmt.Sprintf(", %d image(s)", u.Images)
+	}
+	if u.CostUSD != nil {
+		s += fmt.Sprintf(", est. cost $%.6f", *u.CostUSD)
+	} else {
+		s += ", cost unknown"
+	}
+	return s
+}
+
+// findModel looks up a model by ID or display name, case-insensitively.
+func findModel(all []models.Model, name string) *models.Model {
+	for i := range all {
+		if strings.EqualFold(all[i].ID, name) {
+			return &all[i]
+		}
+	}
+	for i := range all {
+		if strings.EqualFold(all[i].Metadata.DisplayName, name) {
+			return &all[i]
+		}
+	}
+	return nil
+}
+
+// priceUsage applies catalog pricing for bot to u. Catalog errors are ignored
+// since cost reporting is best-effort.
+func priceUsage(ctx context.Context, u *QueryUsage, bot string) {
+	all, err := cache.get(ctx)
+	if err != nil {
+		return
+	}
+	if m := findModel(all, bot); m != nil {
+		u.applyPricing(m.Pricing)
+	}
+}
+
+// requestUsage estimates the usage of one request sent to bot. The whole
+// conversation counts as prompt, including tool calls and results resent by
+// the tool loop. resp is nil when the request failed.
+func requestUsage(req *types.QueryRequest, resp *client.BotResponse) *QueryUsage {
+	var prompt, completion strings.Builder
+	images := 0
+	for _, msg := range req.Query {
+		prompt.WriteString(msg.Content)
+		images += countImages(msg.Attachments)
+	}
+	for _, call := range req.ToolCalls {
+		prompt.WriteString(call.Function.Arguments)
+	}
+	for _, res := range req.ToolResults {
+		prompt.WriteString(res.Content)
+	}
+	var data []map[string]any
+	if resp != nil {
+		completion.WriteString(resp.Text)
+		for _, call := range resp.ToolCalls {
+			completion.WriteString(call.Function.Arguments)
+		}
+		images += countImages(resp.Attachments)
+		data = resp.Data
+	}
+	u := newQueryUsage(prompt.String(), completion.String(), images, data)
+	u.Requests = 1
+	return u
+}
+
+// usageMeter accumulates the usage of every request sent for one query,
+// whether it succeeded, failed or came back empty. It is safe for concurrent
+// use, and a nil meter ignores requests.
+type usageMeter struct {
+	// attachedText is the size in bytes of the text files attached to the
+	// query. Poe parses them into the prompt of every request, so they are
+	// counted as prompt when token counts are estimated.
+	attachedText int64
+
+	mu sync.Mutex
+	u  QueryUsage
+}
+
+// observe adds the usage of req, answered with resp (nil on failure).
+func (m *usageMeter) observe(req *types.QueryRequest, resp *client.BotResponse) {
+	if m == nil {
+		return
+	}
+	r := requestUsage(req, resp)
+	if r.Estimated {
+		r.PromptTokens += int(m.attachedText / charsPerToken)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.u.PromptTokens += r.PromptTokens
+	m.u.CompletionTokens += r.CompletionTokens
+	m.u.Images += r.Images
+	m.u.Requests += r.Requests
+	m.u.Estimated = m.u.Estimated || r.Estimated
+	if r.Reported != nil {
+		m.u.Reported = r.Reported
+	}
+}
+
+// usage returns the accumulated usage priced for bot, or nil if no request
+// was sent.
+func (m *usageMeter) usage(ctx context.Context, bot string) *QueryUsage {
+	m.mu.Lock()
+	u := m.u
+	m.mu.Unlock()
+	if u.Requests == 0 {
+		return nil
+	}
+	priceUsage(ctx, &u, bot)
+	return &u
+}
+
+// sumUsage adds up the usage of queries sent to several bots, or returns nil
+// if there is none. The cost is unknown unless every part is priced.
+func sumUsage(parts []*QueryUsage) *QueryUsage {
+	switch len(parts) {
+	case 0:
+		return nil
+	case 1:
+		return parts[0]
+	}
+	total := &QueryUsage{}
+	var cost float64
+	priced := true
+	for _, u := range parts {
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.Images += u.Images
+		total.Requests += u.Requests
+		total.Estimated = total.Estimated || u.Estimated
+		if u.Reported != nil {
+			total.Reported = u.Reported
+		}
+		if u.CostUSD == nil {
+			priced = false
+		} else {
+			cost += *u.CostUSD
+		}
+	}
+	if priced {
+		total.CostUSD = &cost
+	}
+	return total
+}
+
+// BotUsage aggregates usage for a single bot.
+type BotUsage struct {
+	Bot              string  `json:"bot"`
+	Queries          int     `json:"queries"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	UnpricedQueries  int     `json:"unpriced_queries"`
+}
+
+// UsageTotals defines the structured output of the get_usage tool.
+type UsageTotals struct {
+	Queries          int        `json:"queries"`
+	Requests         int        `json:"requests" jsonschema:"Requests sent to Poe, including tool loop round trips, map/reduce steps and failed attempts"`
+	PromptTokens     int        `json:"prompt_tokens"`
+	CompletionTokens int        `json:"completion_tokens"`
+	CostUSD          float64    `json:"cost_usd"`
+	UnpricedQueries  int        `json:"unpriced_queries" jsonschema:"Queries whose cost could not be estimated and is missing from cost_usd"`
+	ByBot            []BotUsage `json:"by_bot,omitempty"`
+}
+
+// usageTracker keeps running usage totals for the server session.
+type usageTracker struct {
+	mu    sync.Mutex
+	byBot map[string]*BotUsage
+}
+
+var sessionUsage = &usageTracker{}
+
+// record adds one query's usage to the totals.
+func (t *usageTracker) record(bot string, u *QueryUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byBot == nil {
+		t.byBot = make(map[string]*BotUsage)
+	}
+	b, ok := t.byBot[bot]
+	if !ok {
+		b = &BotUsage{Bot: bot}
+		t.byBot[bot] = b
+	}
+	b.add(u)
+}
+
+func (b *BotUsage) add(u *QueryUsage) {
+	b.Queries++
+	b.Requests += u.Requests
+	b.PromptTokens += u.PromptTokens
+	b.CompletionTokens += u.CompletionTokens
+	if u.CostUSD != nil {
+		b.CostUSD += *u.CostUSD
+	} else {
+		b.UnpricedQueries++
+	}
+}
+
+// totals returns a snapshot of the session totals, with per-bot rows sorted
+// by bot name.
+func (t *usageTracker) totals() UsageTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out UsageTotals
+	for _, b := range t.byBot {
+		out.Queries += b.Queries
+		out.Requests += b.Requests
+		out.PromptTokens += b.PromptTokens
+		out.CompletionTokens += b.CompletionTokens
+		out.CostUSD += b.CostUSD
+		out.UnpricedQueries += b.UnpricedQueries
+		out.ByBot = append(out.ByBot, *b)
+	}
+	sort.Slice(out.ByBot, func(i, j int) bool { return out.ByBot[i].Bot < out.ByBot[j].Bot })
+	return out
+}
+
+// formatUsageTotals formats session totals as readable text.
+func formatUsageTotals(t UsageTotals) string {
+	if t.Queries == 0 {
+		return "No queries made in this session."
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Session usage: %d queries (%d requests), %d prompt + %d completion tokens, est. cost $%.6f\n",
+		t.Queries, t.Requests, t.PromptTokens, t.CompletionTokens, t.CostUSD)
+	if t.UnpricedQueries > 0 {
+		fmt.Fprintf(&sb, "%d queries had no catalog pricing and are not included in the cost.\n", t.UnpricedQueries)
+	}
+	for _, b := range t.ByBot {
+		fmt.Fprintf(&sb, "- %s: %d queries (%d requests), %d prompt + %d completion tokens, $%.6f\n",
+			b.Bot, b.Queries, b.Requests, b.PromptTokens, b.CompletionTokens, b.CostUSD)
+	}
+	return sb.String()
+}
+
+// GetUsageArgs defines the (empty) input schema for the get_usage tool.
+type GetUsageArgs struct{}
+
+func registerGetUsage(server *mcp.Server) {
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "get_usage",
+		Description: "Show running token usage and estimated cost totals for query_bot and query_large_document calls in this session",
+	}, handleGetUsage)
+}
+
+func handleGetUsage(ctx context.Context, req *mcp.CallToolRequest, args GetUsageArgs) (*mcp.CallToolResult, UsageTotals, error) {
+	totals := sessionUsage.totals()
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: formatUsageTotals(totals)},
+		},
+	}, totals, nil
+}
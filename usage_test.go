@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/n0madic/go-poe/client"
+	"github.com/n0madic/go-poe/models"
+	"github.com/n0madic/go-poe/types"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"abc", 1},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"привет", 2}, // counted in runes, not bytes
+	}
+	for _, tt := range tests {
+		if got := estimateTokens(tt.in); got != tt.want {
+			t.Errorf("estimateTokens(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPricing(t *testing.T) {
+	gpt := sampleModels()[0] // prompt=0.000005 completion=0.000015
+	u := &QueryUsage{PromptTokens: 1000, CompletionTokens: 2000}
+	u.applyPricing(gpt.Pricing)
+	if u.CostUSD == nil {
+		t.Fatal("expected cost")
+	}
+	if want := 0.005 + 0.03; math.Abs(*u.CostUSD-want) > 1e-12 {
+		t.Errorf("cost = %v, want %v", *u.CostUSD, want)
+	}
+
+	dalle := sampleModels()[2] // image=0.04
+	u = &QueryUsage{PromptTokens: 10, Images: 2}
+	u.applyPricing(dalle.Pricing)
+	if u.CostUSD == nil || math.Abs(*u.CostUSD-0.08) > 1e-12 {
+		t.Errorf("image cost = %v, want 0.08", u.CostUSD)
+	}
+
+	u = &QueryUsage{PromptTokens: 10}
+	u.applyPricing(nil)
+	if u.CostUSD != nil {
+		t.Error("expected no cost without pricing")
+	}
+	if !strings.Contains(u.String(), "cost unknown") {
+		t.Errorf("String() = %q, want 'cost unknown'", u.String())
+	}
+}
+
+func TestNewQueryUsageReported(t *testing.T) {
+	data := []map[string]any{
+		{"choices": []any{}},
+		{"usage": map[string]any{"prompt_tokens": float64(12), "completion_tokens": float64(34)}},
+	}
+	u := newQueryUsage("some prompt text", "answer", 0, data)
+	if u.Estimated {
+		t.Error("expected reported usage, got estimate")
+	}
+	if u.PromptTokens != 12 || u.CompletionTokens != 34 {
+		t.Errorf("tokens = %d/%d, want 12/34", u.PromptTokens, u.CompletionTokens)
+	}
+
+	u = newQueryUsage("abcdefgh", "abcd", 0, nil)
+	if !u.Estimated || u.PromptTokens != 2 || u.CompletionTokens != 1 {
+		t.Errorf("estimate = %+v, want 2/1 estimated", u)
+	}
+}
+
+func TestUsageMeter(t *testing.T) {
+	orig := cache
+	defer func() { cache = orig }()
+	cache = &modelCache{models: sampleModels(), fetchedAt: time.Now()}
+
+	req := &types.QueryRequest{Query: []types.ProtocolMessage{{Role: "user", Content: "abcdefgh"}}}
+	var m usageMeter
+	m.observe(req, &client.BotResponse{
+		ToolCalls: []types.ToolCallDefinition{{ID: "1", Function: types.FunctionCallDefinition{Name: "t", Arguments: "abcd"}}},
+	})
+	// The tool loop resends the conversation with the call and its result.
+	req.ToolCalls = append(req.ToolCalls, types.ToolCallDefinition{ID: "1", Function: types.FunctionCallDefinition{Name: "t", Arguments: "abcd"}})
+	req.ToolResults = append(req.ToolResults, types.ToolResultDefinition{Role: "tool", ToolCallID: "1", Content: "abcdefgh"})
+	m.observe(req, &client.BotResponse{Text: "abcdabcd"})
+	// A failed request still sent its prompt.
+	m.observe(req, nil)
+
+	u := m.usage(context.Background(), "no-such-bot")
+	if u == nil {
+		t.Fatal("expected usage")
+	}
+	if u.Requests != 3 {
+		t.Errorf("requests = %d, want 3", u.Requests)
+	}
+	if want := 2 + 5 + 5; u.PromptTokens != want {
+		t.Errorf("prompt tokens = %d, want %d", u.PromptTokens, want)
+	}
+	if want := 1 + 2; u.CompletionTokens != want {
+		t.Errorf("completion tokens = %d, want %d", u.CompletionTokens, want)
+	}
+	if !strings.Contains(u.String(), "over 3 requests") {
+		t.Errorf("String() = %q, want request count", u.String())
+	}
+
+	var nilMeter *usageMeter
+	nilMeter.observe(req, nil) // must not panic
+	if u := (&usageMeter{}).usage(context.Background(), "x"); u != nil {
+		t.Errorf("usage with no requests = %+v, want nil", u)
+	}
+}
+
+func TestSumUsage(t *testing.T) {
+	cost := func(v float64) *float64 { return &v }
+	if sumUsage(nil) != nil {
+		t.Error("sumUsage(nil) should be nil")
+	}
+	a := &QueryUsage{PromptTokens: 10, CompletionTokens: 0, Requests: 2, Estimated: true, CostUSD: cost(0.5)}
+	b := &QueryUsage{PromptTokens: 10, CompletionTokens: 5, Requests: 1, CostUSD: cost(0.25)}
+	if got := sumUsage([]*QueryUsage{a}); got != a {
+		t.Errorf("single usage = %+v, want it unchanged", got)
+	}
+	got := sumUsage([]*QueryUsage{a, b})
+	if got.PromptTokens != 20 || got.CompletionTokens != 5 || got.Requests != 3 || !got.Estimated || got.CostUSD == nil || *got.CostUSD != 0.75 {
+		t.Errorf("sum = %+v", got)
+	}
+	b.CostUSD = nil
+	if got := sumUsage([]*QueryUsage{a, b}); got.CostUSD != nil {
+		t.Errorf("cost = %v, want unknown when a bot is unpriced", *got.CostUSD)
+	}
+}
+
+func TestApplyPricingPerRequest(t *testing.T) {
+	price := "0.01"
+	u := &QueryUsage{Requests: 3}
+	u.applyPricing(&models.Pricing{Request: &price})
+	if u.CostUSD == nil || math.Abs(*u.CostUSD-0.03) > 1e-12 {
+		t.Errorf("cost = %v, want 0.03 for three requests", u.CostUSD)
+	}
+}
+
+func TestFindModel(t *testing.T) {
+	all := sampleModels()
+	if m := findModel(all, "GPT-4O"); m == nil || m.ID != "gpt-4o" {
+		t.Errorf("findModel by ID = %v, want gpt-4o", m)
+	}
+	if m := findModel(all, "gemini 2.5 pro"); m == nil || m.ID != "gemini-2.5-pro" {
+		t.Errorf("findModel by display name = %v, want gemini-2.5-pro", m)
+	}
+	if m := findModel(all, "unknown"); m != nil {
+		t.Errorf("findModel(unknown) = %v, want nil", m)
+	}
+}
+
+func TestUsageTracker(t *testing.T) {
+	var tr usageTracker
+	cost := 0.5
+	tr.record("GPT-4o", &QueryUsage{PromptTokens: 10, CompletionTokens: 20, CostUSD: &cost})
+	tr.record("GPT-4o", &QueryUsage{PromptTokens: 1, CompletionTokens: 2, CostUSD: &cost})
+	tr.record("Custom-Bot", &QueryUsage{PromptTokens: 5, CompletionTokens: 5})
+
+	totals := tr.totals()
+	if totals.Queries != 3 || totals.PromptTokens != 16 || totals.CompletionTokens != 27 {
+		t.Errorf("totals = %+v", totals)
+	}
+	if totals.CostUSD != 1.0 || totals.UnpricedQueries != 1 {
+		t.Errorf("cost = %v unpriced = %d, want 1.0 and 1", totals.CostUSD, totals.UnpricedQueries)
+	}
+	if len(totals.ByBot) != 2 || totals.ByBot[0].Bot != "Custom-Bot" {
+		t.Errorf("by_bot = %+v, want 2 rows sorted by name", totals.ByBot)
+	}
+
+	out := formatUsageTotals(totals)
+	if !strings.Contains(out, "3 queries") || !strings.Contains(out, "not included in the cost") {
+		t.Errorf("formatUsageTotals missing totals\ngot: %s", out)
+	}
+}
+
+func TestHandleGetUsageEmpty(t *testing.T) {
+	orig := sessionUsage
+	defer func() { sessionUsage = orig }()
+	sessionUsage = &usageTracker{}
+
+	res, totals, err := handleGetUsage(context.Background(), nil, GetUsageArgs{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if totals.Queries != 0 {
+		t.Errorf("queries = %d, want 0", totals.Queries)
+	}
+	text, ok := res.Content[0].(*mcp.TextContent)
+	if !ok || !strings.Contains(text.Text, "No queries") {
+		t.Errorf("content = %v, want 'No queries' text", res.Content)
+	}
+
+	// AddTool panics if the output schema cannot be derived.
+	registerGetUsage(mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, nil))
+}
+
+func TestCountImages(t *testing.T) {
+	atts := []types.Attachment{
+		{ContentType: "image/png"},
+		{ContentType: "application/pdf"},
+		{ContentType: "image/jpeg"},
+		{Name: "photo.webp"},
+		{Name: "notes.txt"},
+	}
+	if n := countImages(atts); n != 3 {
+		t.Errorf("countImages = %d, want 3", n)
+	}
+}
+
+func TestUsageMeterAttachments(t *testing.T) {
+	orig := cache
+	defer func() { cache = orig }()
+	cache = &modelCache{models: sampleModels(), fetchedAt: time.Now()}
+
+	// 400 bytes of uploaded text and one image, sent with every request.
+	req := &types.QueryRequest{Query: []types.ProtocolMessage{{
+		Role:        "user",
+		Content:     "abcd",
+		Attachments: []types.Attachment{{Name: "notes.txt", ContentType: "text/plain"}, {Name: "chart.png", ContentType: "image/png"}},
+	}}}
+	m := &usageMeter{attachedText: 400}
+	m.observe(req, &client.BotResponse{Text: "abcd"})
+	m.observe(req, &client.BotResponse{Text: "abcd"})
+	u := m.usage(context.Background(), "no-such-bot")
+	if want := 2 * (1 + 100); u.PromptTokens != want {
+		t.Errorf("prompt tokens = %d, want %d", u.PromptTokens, want)
+	}
+	if u.Images != 2 {
+		t.Errorf("images = %d, want 2", u.Images)
+	}
+
+	price := "0.04"
+	u.applyPricing(&models.Pricing{Image: &price})
+	if u.CostUSD == nil || *u.CostUSD != 0.08 {
+		t.Errorf("cost = %v, want the image price per image sent", u.CostUSD)
+	}
+
+	var none *QueryUsage
+	if got := none.String(); got != "unknown" {
+		t.Errorf("nil String() = %q", got)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// webPageCacheTTL is how long a fetched page is reused before it is fetched
+// again.
+const webPageCacheTTL = 15 * time.Minute
+
+// maxTitleRunes caps the page title used as a file name.
+const maxTitleRunes = 80
+
+// webPageEntry is a fetched and converted URL.
+type webPageEntry struct {
+	Name     string
+	Data     []byte
+	StoredAt time.Time
+}
+
+// webPageCache maps URLs to pages already fetched in web mode, so repeated
+// queries about the same page do not fetch and convert it again.
+type webPageCache struct {
+	mu      sync.Mutex
+	entries map[string]webPageEntry
+	ttl     time.Duration
+}
+
+var webPages = &webPageCache{entries: map[string]webPageEntry{}, ttl: webPageCacheTTL}
+
+func (c *webPageCache) get(key string) (webPageEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return webPageEntry{}, false
+	}
+	if time.Since(e.StoredAt) >= c.ttl {
+		delete(c.entries, key)
+		return webPageEntry{}, false
+	}
+	return e, true
+}
+
+func (c *webPageCache) put(key string, e webPageEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.StoredAt = time.Now()
+	c.entries[key] = e
+}
+
+// fetchWebPages replaces URL attachments with their content, fetched locally
+// under the URL policy and header rules. HTML pages are reduced to their main
+// readable content and converted to markdown named after the page title;
+// other content types are attached as downloaded.
+func fetchWebPages(ctx context.Context, atts []attachment) ([]attachment, error) {
+	out := make([]attachment, len(atts))
+	for i, a := range atts {
+		out[i] = a
+		if a.Data != nil || !isURL(a.Path) {
+			continue
+		}
+		page, ok := webPages.get(a.Path)
+		if !ok {
+			fetched, err := cfg.fetch(ctx, a.Path)
+			if err != nil {
+				return nil, err
+			}
+			page = webPageFromFetch(fetched)
+			webPages.put(a.Path, page)
+		}
+		out[i] = attachment{Source: a.Source, Name: page.Name, Data: page.Data}
+	}
+	return out, nil
+}
+
+// webPageFromFetch converts a downloaded URL into an attachment.
+func webPageFromFetch(f *fetchedURL) webPageEntry {
+	mediaType, _, _ := mime.ParseMediaType(f.ContentType)
+	u, _ := url.Parse(f.FinalURL)
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		title, text := readablePage(f.Data, f.ContentType, u)
+		name := safeFileName(truncateRunes(title, maxTitleRunes))
+		if title == "" {
+			name = safeFileName(u.Host + strings.TrimSuffix(u.Path, "/"))
+		}
+		return webPageEntry{Name: name + ".md", Data: []byte(text)}
+	}
+	return webPageEntry{Name: f.fileName(), Data: f.Data}
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:n]))
+}
+
+// readablePage extracts the title and main content of an HTML page as
+// markdown, with links resolved against base. The page is decoded to UTF-8
+// using the charset from contentType, a <meta> tag or a byte-order mark.
+func readablePage(data []byte, contentType string, base *url.URL) (title, markdown string) {
+	r, err := charset.NewReader(bytes.NewReader(data), contentType)
+	if err != nil {
+		r = bytes.NewReader(data)
+	}
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", string(data)
+	}
+	title = pageTitle(doc)
+	w := &markdownWriter{base: base}
+	content := strings.TrimSpace(w.blocks(contentRoot(doc)))
+	if title != "" {
+		content = strings.TrimPrefix(content, "# "+title)
+	}
+	var sb strings.Builder
+	if title != "" {
+		fmt.Fprintf(&sb, "# %s\n\n", title)
+	}
+	if base != nil {
+		fmt.Fprintf(&sb, "Source: %s\n\n", base)
+	}
+	sb.WriteString(strings.TrimSpace(content))
+	sb.WriteString("\n")
+	return title, sb.String()
+}
+
+// pageTitle returns the og:title, <title> or first <h1> of a page.
+func pageTitle(doc *html.Node) string {
+	var og, title, h1 string
+	walkHTML(doc, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Meta:
+			if htmlAttr(n, "property") == "og:title" && og == "" {
+				og = collapseSpace(htmlAttr(n, "content"))
+			}
+		case atom.Title:
+			if title == "" {
+				title = collapseSpace(textContent(n))
+			}
+		case atom.H1:
+			if h1 == "" {
+				h1 = collapseSpace(textContent(n))
+			}
+		}
+		return true
+	})
+	for _, t := range []string{og, title, h1} {
+		if t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+// contentRoot picks the element holding the page's main content: the
+// largest <article>, else <main>, else the element whose paragraphs hold the
+// most text, else <body>.
+func contentRoot(doc *html.Node) *html.Node {
+	var body, main, best *html.Node
+	bestLen := 0
+	scores := map[*html.Node]int{}
+	walkHTML(doc, func(n *html.Node) bool {
+		if skipElement(n) {
+			return false
+		}
+		switch {
+		case n.DataAtom == atom.Body:
+			body = n
+		case n.DataAtom == atom.Main || htmlAttr(n, "role") == "main":
+			if main == nil {
+				main = n
+			}
+		case n.DataAtom == atom.Article:
+			if l := len(collapseSpace(textContent(n))); l > bestLen {
+				best, bestLen = n, l
+			}
+		case n.DataAtom == atom.P || n.DataAtom == atom.Pre:
+			l := len(collapseSpace(textContent(n)))
+			if p := n.Parent; p != nil {
+				scores[p] += l
+				if gp := p.Parent; gp != nil {
+					scores[gp] += l / 2
+				}
+			}
+		}
+		return true
+	})
+	if best != nil {
+		return best
+	}
+	if main != nil {
+		return main
+	}
+	var top *html.Node
+	for n, s := range scores {
+		if top == nil || s > scores[top] {
+			top = n
+		}
+	}
+	if top != nil && scores[top] >= 200 {
+		return top
+	}
+	if body != nil {
+		return body
+	}
+	return doc
+}
+
+// skippedTags never hold readable content.
+var skippedTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Svg: true, atom.Canvas: true, atom.Iframe: true, atom.Object: true,
+	atom.Embed: true, atom.Form: true, atom.Button: true, atom.Input: true,
+	atom.Select: true, atom.Textarea: true, atom.Nav: true, atom.Aside: true,
+	atom.Footer: true, atom.Head: true,
+}
+
+// boilerplate matches class and id values of page furniture.
+var boilerplate = regexp.MustCompile(`(?i)\b(cookies?|consent|share|sharing|social|newsletter|subscribe|advert|ads?|promo|related|comments?|sidebar|breadcrumbs?|popup|modal|menu|skip-link)\b`)
+
+// skipElement reports whether n is hidden, non-content markup or page
+// furniture such as navigation, ads and comment sections.
+func skipElement(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if skippedTags[n.DataAtom] {
+		return true
+	}
+	if _, hidden := attrLookup(n, "hidden"); hidden || htmlAttr(n, "aria-hidden") == "true" {
+		return true
+	}
+	if strings.Contains(strings.ReplaceAll(htmlAttr(n, "style"), " ", ""), "display:none") {
+		return true
+	}
+	if n.DataAtom == atom.Header && containsTag(n, atom.Nav) {
+		return true // site header rather than an article heading
+	}
+	if n.DataAtom == atom.Body || n.DataAtom == atom.Html || n.DataAtom == atom.Main || n.DataAtom == atom.Article {
+		return false
+	}
+	return boilerplate.MatchString(htmlAttr(n, "class")) || boilerplate.MatchString(htmlAttr(n, "id"))
+}
+
+// blockTags start a new markdown block.
+var blockTags = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Blockquote: true, atom.Details: true,
+	atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Fieldset: true,
+	atom.Figcaption: true, atom.Figure: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true,
+	atom.Hgroup: true, atom.Hr: true, atom.Li: true, atom.Main: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Section: true, atom.Summary: true,
+	atom.Table: true, atom.Ul: true, atom.Body: true,
+}
+
+// markdownWriter renders HTML as markdown.
+type markdownWriter struct {
+	base *url.URL
+}
+
+// lineBreak marks <br> through whitespace collapsing.
+const lineBreak = "\x00"
+
+// blocks renders the children of n, grouping inline content into paragraphs.
+func (w *markdownWriter) blocks(n *html.Node) string {
+	var out []string
+	var para strings.Builder
+	flush := func() {
+		if t := finishInline(para.String()); t != "" {
+			out = append(out, t)
+		}
+		para.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if skipElement(c) {
+			continue
+		}
+		if c.Type == html.ElementNode && blockTags[c.DataAtom] {
+			flush()
+			if b := w.block(c); b != "" {
+				out = append(out, b)
+			}
+			continue
+		}
+		para.WriteString(w.inline(c))
+	}
+	flush()
+	return strings.Join(out, "\n\n")
+}
+
+func (w *markdownWriter) block(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := finishInline(w.inlineChildren(n))
+		if text == "" {
+			return ""
+		}
+		level := int(n.Data[1] - '0')
+		return strings.Repeat("#", level) + " " + strings.ReplaceAll(text, "\n", " ")
+	case atom.P, atom.Dt, atom.Dd, atom.Summary, atom.Figcaption:
+		return finishInline(w.inlineChildren(n))
+	case atom.Hr:
+		return "---"
+	case atom.Pre:
+		lang := ""
+		walkHTML(n, func(c *html.Node) bool {
+			for _, class := range strings.Fields(htmlAttr(c, "class")) {
+				if l, ok := strings.CutPrefix(class, "language-"); ok && lang == "" {
+					lang = l
+				}
+			}
+			return true
+		})
+		return codeFence(lang, strings.Trim(textContent(n), "\n"))
+	case atom.Blockquote:
+		inner := w.blocks(n)
+		if inner == "" {
+			return ""
+		}
+		return "> " + strings.ReplaceAll(inner, "\n", "\n> ")
+	case atom.Ul, atom.Ol:
+		return w.list(n)
+	case atom.Table:
+		return w.table(n)
+	default:
+		return w.blocks(n)
+	}
+}
+
+// list renders list items, indenting nested content under each marker.
+func (w *markdownWriter) list(n *html.Node) string {
+	var items []string
+	i := 0
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom != atom.Li || skipElement(c) {
+			continue
+		}
+		i++
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+		text := w.blocks(c)
+		if text == "" {
+			continue
+		}
+		indent := strings.Repeat(" ", len(marker))
+		text = strings.ReplaceAll(text, "\n\n", "\n")
+		items = append(items, marker+strings.ReplaceAll(text, "\n", "\n"+indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+// table renders rows of th and td cells, ignoring nested tables' structure.
+func (w *markdownWriter) table(n *html.Node) string {
+	var rows [][]string
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Tr:
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						text := finishInline(w.inlineChildren(cell))
+						row = append(row, strings.ReplaceAll(text, "\n", " "))
+					}
+				}
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				visit(c)
+			}
+		}
+	}
+	visit(n)
+	return strings.TrimSuffix(markdownTable(rows), "\n")
+}
+
+func (w *markdownWriter) inlineChildren(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(w.inline(c))
+	}
+	return sb.String()
+}
+
+// inline renders n as inline markdown. Whitespace is collapsed later by
+// finishInline.
+func (w *markdownWriter) inline(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type != html.ElementNode || skipElement(n) {
+		return ""
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		return lineBreak
+	case atom.A:
+		text := strings.TrimSpace(collapseSpace(w.inlineChildren(n)))
+		href := w.resolve(htmlAttr(n, "href"))
+		if text == "" || href == "" {
+			return text
+		}
+		return "[" + text + "](" + href + ")"
+	case atom.Img:
+		src := w.resolve(htmlAttr(n, "src"))
+		if src == "" {
+			return ""
+		}
+		return "![" + collapseSpace(htmlAttr(n, "alt")) + "](" + src + ")"
+	case atom.Code, atom.Kbd, atom.Samp:
+		text := collapseSpace(textContent(n))
+		if text == "" {
+			return ""
+		}
+		return "`" + text + "`"
+	case atom.Strong, atom.B:
+		return wrapInline(w.inlineChildren(n), "**")
+	case atom.Em, atom.I:
+		return wrapInline(w.inlineChildren(n), "*")
+	default:
+		if blockTags[n.DataAtom] {
+			// Blocks inside inline content, such as a <div> in a link.
+			return " " + w.blocks(n) + " "
+		}
+		return w.inlineChildren(n)
+	}
+}
+
+// wrapInline surrounds text with a markdown emphasis marker, keeping
+// surrounding spaces outside it.
+func wrapInline(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	lead := text[:len(text)-len(strings.TrimLeft(text, " \t\n"))]
+	trail := text[len(strings.TrimRight(text, " \t\n")):]
+	return lead + marker + trimmed + marker + trail
+}
+
+// resolve makes href absolute, dropping fragment-only and script links.
+func (w *markdownWriter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if w.base != nil {
+		u = w.base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+// finishInline collapses whitespace in rendered inline content and turns
+// line break markers into newlines.
+func finishInline(s string) string {
+	s = collapseSpace(s)
+	s = strings.ReplaceAll(s, " "+lineBreak, lineBreak)
+	s = strings.ReplaceAll(s, lineBreak+" ", lineBreak)
+	return strings.Trim(strings.ReplaceAll(s, lineBreak, "\n"), "\n ")
+}
+
+// collapseSpace replaces runs of whitespace with single spaces and trims the
+// ends.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// textContent returns the text inside n, excluding skipped elements.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	walkHTML(n, func(c *html.Node) bool {
+		if c != n && skipElement(c) {
+			return false
+		}
+		if c.Type == html.TextNode {
+			sb.WriteString(c.Data)
+		}
+		return true
+	})
+	return sb.String()
+}
+
+// walkHTML visits n and its descendants in document order; returning false
+// skips a node's children.
+func walkHTML(n *html.Node, visit func(*html.Node) bool) {
+	if !visit(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkHTML(c, visit)
+	}
+}
+
+func containsTag(n *html.Node, a atom.Atom) bool {
+	found := false
+	walkHTML(n, func(c *html.Node) bool {
+		found = found || c.DataAtom == a
+		return !found
+	})
+	return found
+}
+
+func attrLookup(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	v, _ := attrLookup(n, key)
+	return v
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+)
+
+const articleHTML = `<!DOCTYPE html>
+<html><head>
+<title>Ignored site title</title>
+<meta property="og:title" content="Go 1.23 is released">
+<style>body { color: red }</style>
+<script>track()</script>
+</head>
+<body>
+<header><nav><a href="/">Home</a> <a href="/blog">Blog</a></nav></header>
+<div class="cookie-banner">We use cookies</div>
+<article>
+<h1>Go 1.23 is released</h1>
+<p>Today the Go team is happy to release <strong>Go 1.23</strong>.
+See the <a href="/doc/go1.23">release notes</a> for details.</p>
+<h2>Iterators</h2>
+<p>Range over <code>func</code> types:<br>now supported.</p>
+<ul><li>First item</li><li>Second <em>item</em><ul><li>Nested</li></ul></li></ul>
+<pre><code class="language-go">for x := range seq {
+	fmt.Println(x)
+}</code></pre>
+<table><thead><tr><th>Version</th><th>Date</th></tr></thead>
+<tbody><tr><td>1.23</td><td>August</td></tr></tbody></table>
+<blockquote><p>Quoted text</p></blockquote>
+<img src="gopher.png" alt="Gopher">
+<div class="share-buttons"><a href="https://social.example">Share</a></div>
+<p hidden>Secret</p>
+</article>
+<aside>Related posts</aside>
+<footer>Copyright</footer>
+</body></html>`
+
+func TestReadablePage(t *testing.T) {
+	base, _ := url.Parse("https://go.dev/blog/go1.23")
+	title, md := readablePage([]byte(articleHTML), "text/html", base)
+	if title != "Go 1.23 is released" {
+		t.Errorf("title = %q", title)
+	}
+	want := "# Go 1.23 is released\n\n" +
+		"Source: https://go.dev/blog/go1.23\n\n" +
+		"Today the Go team is happy to release **Go 1.23**. See the [release notes](https://go.dev/doc/go1.23) for details.\n\n" +
+		"## Iterators\n\n" +
+		"Range over `func` types:\nnow supported.\n\n" +
+		"- First item\n- Second *item*\n  - Nested\n\n" +
+		"```go\nfor x := range seq {\n\tfmt.Println(x)\n}\n```\n\n" +
+		"| Version | Date |\n| --- | --- |\n| 1.23 | August |\n\n" +
+		"> Quoted text\n\n" +
+		"![Gopher](https://go.dev/blog/gopher.png)\n"
+	if md != want {
+		t.Errorf("markdown =\n%s\nwant\n%s", md, want)
+	}
+	for _, junk := range []string{"Home", "cookies", "Share", "Secret", "Related", "Copyright", "track()"} {
+		if strings.Contains(md, junk) {
+			t.Errorf("markdown contains boilerplate %q", junk)
+		}
+	}
+}
+
+func TestReadablePage_NoArticle(t *testing.T) {
+	page := `<html><head><title>Notes</title></head><body>
+<div id="menu"><a href="/a">A</a></div>
+<div id="content"><p>` + strings.Repeat("Long paragraph text. ", 20) + `</p><p>Second paragraph.</p></div>
+</body></html>`
+	title, md := readablePage([]byte(page), "text/html", nil)
+	if title != "Notes" {
+		t.Errorf("title = %q", title)
+	}
+	if !strings.Contains(md, "Second paragraph.") || strings.Contains(md, "[A]") {
+		t.Errorf("markdown =\n%s", md)
+	}
+}
+
+func TestWebPageFromFetchCharset(t *testing.T) {
+	for name, f := range map[string]*fetchedURL{
+		// "Привет" in windows-1251, declared by the Content-Type header.
+		"header": {
+			Data:        []byte("<html><head><title>\xcf\xf0\xe8\xe2\xe5\xf2</title></head><body><p>\xcf\xf0\xe8\xe2\xe5\xf2</p></body></html>"),
+			ContentType: "text/html; charset=windows-1251",
+			FinalURL:    "https://example.com/ru",
+		},
+		// "Привет" in windows-1251, declared by a <meta> tag.
+		"meta": {
+			Data:        []byte(`<html><head><meta charset="windows-1251"><title>` + "\xcf\xf0\xe8\xe2\xe5\xf2</title></head><body><p>\xcf\xf0\xe8\xe2\xe5\xf2</p></body></html>"),
+			ContentType: "text/html",
+			FinalURL:    "https://example.com/ru",
+		},
+	} {
+		page := webPageFromFetch(f)
+		if page.Name != "Привет.md" || !utf8.Valid(page.Data) || strings.Count(string(page.Data), "Привет") != 2 {
+			t.Errorf("%s: got %q: %q", name, page.Name, page.Data)
+		}
+	}
+}
+
+func TestFetchWebPages(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/article":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(articleHTML))
+		case "/data.csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("a,b\n1,2\n"))
+		}
+	}))
+	defer srv.Close()
+	defer func(p urlPolicy) { cfg.URLPolicy = p }(cfg.URLPolicy)
+	cfg.URLPolicy.AllowPrivate = true
+	defer func() { webPages = &webPageCache{entries: map[string]webPageEntry{}, ttl: webPageCacheTTL} }()
+
+	atts := []attachment{
+		{Source: srv.URL + "/article", Name: "article", Path: srv.URL + "/article"},
+		{Source: srv.URL + "/data.csv", Name: "data.csv", Path: srv.URL + "/data.csv"},
+		{Source: "local.txt", Name: "local.txt", Path: "local.txt"},
+	}
+	got, err := fetchWebPages(context.Background(), atts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Name != "Go 1.23 is released.md" || !strings.Contains(string(got[0].Data), "**Go 1.23**") {
+		t.Errorf("page = %s %q", got[0].Name, got[0].Data)
+	}
+	if got[1].Name != "data.csv" || string(got[1].Data) != "a,b\n1,2\n" {
+		t.Errorf("csv = %s %q", got[1].Name, got[1].Data)
+	}
+	if got[2].Path != "local.txt" || got[2].Data != nil {
+		t.Errorf("local file changed: %+v", got[2])
+	}
+
+	if _, err := fetchWebPages(context.Background(), atts); err != nil {
+		t.Fatal(err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("server hit %d times, want 2 (second call cached)", n)
+	}
+}
+
+func TestFetchWebPages_Policy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	atts := []attachment{{Source: srv.URL, Name: "x", Path: srv.URL}}
+	if _, err := fetchWebPages(context.Background(), atts); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("err = %v, want the URL policy to block a loopback address", err)
+	}
+}